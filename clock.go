@@ -0,0 +1,29 @@
+package godistcache
+
+import "time"
+
+// Tells the cache what time it is, every expiration, the janitor and the persistence loop go through it
+// Use WithClock to swap in a fake one, godistcachetest has a manual clock for tests
+type Clock interface {
+	// Returns the current time
+	Now() time.Time
+	// Returns a channel that receives the time once d has passed
+	After(d time.Duration) <-chan time.Time
+}
+
+// The clock used by default, backed by the time package
+var RealClock Clock = realClock{}
+
+// Reads the system clock
+type realClock struct{}
+
+// Returns the current time
+func (realClock) Now() time.Time {
+	return time.Now()
+}
+
+// Returns a channel that receives the time once d has passed
+// d -> How long to wait
+func (realClock) After(d time.Duration) <-chan time.Time {
+	return time.After(d)
+}
@@ -0,0 +1,192 @@
+package godistcache
+
+import (
+	"bytes"
+	"encoding/gob"
+	"encoding/json"
+	"fmt"
+	"io"
+	"reflect"
+	"sync"
+	"time"
+
+	"github.com/fxamacker/cbor/v2"
+	"github.com/vmihailenco/msgpack/v5"
+)
+
+// Identifies how the items of a snapshot are encoded, recorded in its header so loading picks the right codec
+type CodecID uint8
+
+const (
+	CodecGob         CodecID = 1 // encoding/gob, the default
+	CodecJSON        CodecID = 2 // encoding/json
+	CodecMessagePack CodecID = 3 // MessagePack
+	CodecCBOR        CodecID = 4 // CBOR, RFC 8949
+)
+
+// Returns the name of the codec if it's registered
+func (id CodecID) String() string {
+	if c, ok := lookupCodec(id); ok {
+		return c.Name()
+	}
+	return fmt.Sprintf("CodecID(%d)", uint8(id))
+}
+
+// Encodes and decodes the items of a cache, register your own with RegisterCodec
+type Codec interface {
+	ID() CodecID                    // Recorded in snapshot headers, unique per codec
+	Name() string                   // Used in errors and logs
+	NewEncoder(w io.Writer) Encoder // Writes values to w
+	NewDecoder(r io.Reader) Decoder // Reads values written by NewEncoder from r
+}
+
+// Writes values to a stream
+type Encoder interface {
+	Encode(v any) error
+}
+
+// Reads values from a stream
+type Decoder interface {
+	Decode(v any) error
+}
+
+var (
+	// encoding/gob, keeps the concrete types of Cache values registered with gob.Register
+	Gob Codec = gobCodec{}
+	// encoding/json, readable by jq and almost any language
+	JSON Codec = jsonCodec{}
+	// MessagePack, compact and readable by most languages
+	MessagePack Codec = msgpackCodec{}
+	// CBOR, compact and standardised as RFC 8949
+	CBOR Codec = cborCodec{}
+)
+
+type gobCodec struct{}
+
+func (gobCodec) ID() CodecID                    { return CodecGob }
+func (gobCodec) Name() string                   { return "gob" }
+func (gobCodec) NewEncoder(w io.Writer) Encoder { return gob.NewEncoder(w) }
+func (gobCodec) NewDecoder(r io.Reader) Decoder { return gob.NewDecoder(r) }
+
+type jsonCodec struct{}
+
+func (jsonCodec) ID() CodecID                    { return CodecJSON }
+func (jsonCodec) Name() string                   { return "json" }
+func (jsonCodec) NewEncoder(w io.Writer) Encoder { return json.NewEncoder(w) }
+func (jsonCodec) NewDecoder(r io.Reader) Decoder {
+	// Numbers decoded into any stay exact instead of going through float64, decodeTyped gives them their type
+	d := json.NewDecoder(r)
+	d.UseNumber()
+	return d
+}
+
+type msgpackCodec struct{}
+
+func (msgpackCodec) ID() CodecID                    { return CodecMessagePack }
+func (msgpackCodec) Name() string                   { return "msgpack" }
+func (msgpackCodec) NewEncoder(w io.Writer) Encoder { return msgpack.NewEncoder(w) }
+func (msgpackCodec) NewDecoder(r io.Reader) Decoder { return msgpack.NewDecoder(r) }
+
+type cborCodec struct{}
+
+func (cborCodec) ID() CodecID                    { return CodecCBOR }
+func (cborCodec) Name() string                   { return "cbor" }
+func (cborCodec) NewEncoder(w io.Writer) Encoder { return cbor.NewEncoder(w) }
+func (cborCodec) NewDecoder(r io.Reader) Decoder { return cbor.NewDecoder(r) }
+
+// The codecs snapshots can be read with, by ID
+var codecs = struct {
+	sync.RWMutex
+	m map[CodecID]Codec
+}{m: map[CodecID]Codec{CodecGob: Gob, CodecJSON: JSON, CodecMessagePack: MessagePack, CodecCBOR: CBOR}}
+
+// Register a codec so WithCodec can use it and snapshots written with it can be loaded, such as one for Protobuf
+// Returns an error if another codec already has its ID, IDs from 128 up are never used by this package
+// c -> The codec to register
+func RegisterCodec(c Codec) error {
+	codecs.Lock()
+	defer codecs.Unlock()
+	if other, ok := codecs.m[c.ID()]; ok {
+		return fmt.Errorf("godistcache: codec ID %d is already used by %v", uint8(c.ID()), other.Name())
+	}
+	codecs.m[c.ID()] = c
+	return nil
+}
+
+// Returns the codec registered with the ID
+// id -> The ID of the codec
+func lookupCodec(id CodecID) (Codec, bool) {
+	codecs.RLock()
+	defer codecs.RUnlock()
+	c, ok := codecs.m[id]
+	return c, ok
+}
+
+// The types Cache values are decoded into by codecs other than gob, by name and the other way around
+var types = struct {
+	sync.RWMutex
+	byName map[string]reflect.Type
+	byType map[reflect.Type]string
+}{byName: map[string]reflect.Type{}, byType: map[reflect.Type]string{}}
+
+func init() {
+	for _, v := range []any{false, "", 0, int8(0), int16(0), int32(0), int64(0), uint(0), uint8(0), uint16(0), uint32(0), uint64(0),
+		float32(0), float64(0), []byte(nil), []string(nil), time.Time{}, time.Duration(0)} {
+		t := reflect.TypeOf(v)
+		types.byName[t.String()], types.byType[t] = t, t.String()
+	}
+}
+
+// Register the type of Cache values under a name, the way gob.Register does for gob
+// Codecs other than gob don't keep the concrete type of a value, so the name is saved next to it and the value decoded back into T
+// Basic types such as string, int, float64, []byte and time.Time are already registered
+// name -> The name saved in snapshots, keep it stable
+func RegisterType[T any](name string) {
+	t := reflect.TypeFor[T]()
+	types.Lock()
+	defer types.Unlock()
+	types.byName[name], types.byType[t] = t, name
+}
+
+// Returns the registered name of the type of v, "" if it isn't registered
+// v -> The value
+func typeName(v any) string {
+	types.RLock()
+	defer types.RUnlock()
+	return types.byType[reflect.TypeOf(v)]
+}
+
+// Returns the type registered under name
+// name -> The name of the type
+func registeredType(name string) (reflect.Type, bool) {
+	types.RLock()
+	defer types.RUnlock()
+	t, ok := types.byName[name]
+	return t, ok
+}
+
+// Decode a value that a codec decoded without knowing its type into the type registered under name
+// Values without a name or with an unregistered one are returned as they are
+// c -> The codec the value was decoded with
+// name -> The name of its type
+// v -> The value
+func decodeTyped(c Codec, name string, v any) (any, error) {
+	t, ok := registeredType(name)
+	// The JSON codec keeps numbers as json.Number, which re-encodes exactly, without a type they are what encoding/json decodes them into
+	if n, isNumber := v.(json.Number); isNumber && !ok {
+		return n.Float64()
+	}
+	if !ok || v == nil || reflect.TypeOf(v) == t {
+		return v, nil
+	}
+	// Re-encoding the generic value is the only way to hand the codec the type
+	var buf bytes.Buffer
+	if err := c.NewEncoder(&buf).Encode(v); err != nil {
+		return nil, err
+	}
+	p := reflect.New(t)
+	if err := c.NewDecoder(&buf).Decode(p.Interface()); err != nil {
+		return nil, fmt.Errorf("decoding a %v: %w", name, err)
+	}
+	return p.Elem().Interface(), nil
+}
@@ -0,0 +1,158 @@
+package godistcache
+
+import (
+	"bytes"
+	"context"
+	"encoding/gob"
+	"errors"
+	"io"
+	"math"
+	"strings"
+	"testing"
+	"time"
+)
+
+// A codec that can't be registered since Gob already uses its ID
+type takenCodec struct{ jsonCodec }
+
+func (takenCodec) ID() CodecID { return CodecGob }
+
+// A codec that was never registered
+type unregisteredCodec struct{ jsonCodec }
+
+func (unregisteredCodec) ID() CodecID { return 201 }
+
+func TestCodecs(t *testing.T) {
+	RegisterType[Object]("godistcache.Object")
+	gob.Register(Object{})
+	gob.Register(time.Time{})
+	for _, codec := range []Codec{Gob, JSON, MessagePack, CBOR} {
+		c, err := New(context.Background(), WithCodec(codec))
+		if err != nil {
+			t.Fatal(err)
+		}
+		obj := Object{One: "One", Two: 2, Three: 3.5}
+		c.Put("obj", obj)
+		c.Put("int", 42)
+		c.Put("time", time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC))
+		c.PutTTL("ttl", "expires", time.Hour)
+		var buf bytes.Buffer
+		if err := c.WriteSnapshot(&buf); err != nil {
+			t.Fatalf("%v: %v", codec.Name(), err)
+		}
+		if h, _ := ReadSnapshotHeader(bytes.NewReader(buf.Bytes())); h.Codec != codec.ID() {
+			t.Fatalf("%v wasn't recorded in the header: %v", codec.Name(), h.Codec)
+		}
+		// Loading picks the codec from the header
+		c2, err := New(context.Background())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := c2.ReadSnapshot(&buf); err != nil {
+			t.Fatalf("%v: %v", codec.Name(), err)
+		}
+		if v, _ := c2.Get("obj"); v != obj {
+			t.Fatalf("%v lost the type of a registered struct: %#v", codec.Name(), v)
+		}
+		if v, _ := c2.Get("int"); v != 42 {
+			t.Fatalf("%v lost the type of an int: %#v", codec.Name(), v)
+		}
+		if v, _ := c2.Get("time"); !v.(time.Time).Equal(time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)) {
+			t.Fatalf("%v lost a time: %v", codec.Name(), v)
+		}
+		if ttl, _ := c2.TTL("ttl"); ttl <= 59*time.Minute {
+			t.Fatalf("%v lost the expiration: %v", codec.Name(), ttl)
+		}
+	}
+}
+
+func TestCodecsLargeIntegers(t *testing.T) {
+	for _, codec := range []Codec{Gob, JSON, MessagePack, CBOR} {
+		c, err := New(context.Background(), WithCodec(codec))
+		if err != nil {
+			t.Fatal(err)
+		}
+		// Past 2^53, where a float64 can't tell integers apart
+		c.Put("int64", int64(1<<60+1))
+		c.Put("uint64", uint64(math.MaxUint64))
+		var buf bytes.Buffer
+		if err := c.WriteSnapshot(&buf); err != nil {
+			t.Fatalf("%v: %v", codec.Name(), err)
+		}
+		c2, err := New(context.Background())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := c2.ReadSnapshot(&buf); err != nil {
+			t.Fatalf("%v: %v", codec.Name(), err)
+		}
+		if v, _ := c2.Get("int64"); v != int64(1<<60+1) {
+			t.Fatalf("%v changed an int64: %v", codec.Name(), v)
+		}
+		if v, _ := c2.Get("uint64"); v != uint64(math.MaxUint64) {
+			t.Fatalf("%v changed a uint64: %v", codec.Name(), v)
+		}
+	}
+	for _, codec := range []Codec{JSON, MessagePack, CBOR} {
+		c := newTestTyped[string, uint64](t, WithCodec(codec))
+		c.Put("max", math.MaxUint64)
+		var buf bytes.Buffer
+		if err := c.WriteSnapshot(&buf); err != nil {
+			t.Fatalf("%v: %v", codec.Name(), err)
+		}
+		c2 := newTestTyped[string, uint64](t)
+		if err := c2.ReadSnapshot(&buf); err != nil {
+			t.Fatalf("%v: %v", codec.Name(), err)
+		}
+		if v, _ := c2.Get("max"); v != math.MaxUint64 {
+			t.Fatalf("%v changed a uint64: %v", codec.Name(), v)
+		}
+	}
+}
+
+func TestTypedCodecs(t *testing.T) {
+	for _, codec := range []Codec{JSON, MessagePack, CBOR} {
+		c := newTestTyped[int, typedObject](t, WithCodec(codec))
+		c.Put(1, typedObject{Name: "a", Tags: []string{"b"}})
+		var buf bytes.Buffer
+		if err := c.WriteSnapshot(&buf); err != nil {
+			t.Fatalf("%v: %v", codec.Name(), err)
+		}
+		c2 := newTestTyped[int, typedObject](t)
+		if err := c2.ReadSnapshot(&buf); err != nil {
+			t.Fatalf("%v: %v", codec.Name(), err)
+		}
+		if v, ok := c2.Get(1); !ok || v.Name != "a" || v.Tags[0] != "b" {
+			t.Fatalf("%v didn't load the item: %v", codec.Name(), v)
+		}
+	}
+}
+
+func TestJSONSnapshotReadable(t *testing.T) {
+	c := newTestTyped[string, string](t, WithCodec(JSON))
+	c.Put("greeting", "hello")
+	var buf bytes.Buffer
+	if err := c.WriteSnapshot(&buf); err != nil {
+		t.Fatal(err)
+	}
+	// Without compression the blocks hold plain JSON
+	b, _ := io.ReadAll(&buf)
+	if !strings.Contains(string(b), `"greeting":{"V":"hello"`) {
+		t.Fatalf("JSON snapshot doesn't hold readable JSON: %q", b)
+	}
+}
+
+func TestRegisterCodec(t *testing.T) {
+	if err := RegisterCodec(takenCodec{}); err == nil {
+		t.Fatalf("Codec with a taken ID was registered")
+	}
+	if _, err := New(context.Background(), WithCodec(unregisteredCodec{})); err == nil {
+		t.Fatalf("Unregistered codec was accepted")
+	}
+	var data bytes.Buffer
+	writeSnapshotHeader(&data, SnapshotHeader{Version: snapshotVersion, Codec: 200})
+	c := newTestTyped[string, string](t)
+	if err := c.ReadSnapshot(&data); !errors.Is(err, ErrCorruptSnapshot) {
+		t.Fatalf("Snapshot with an unknown codec returned %v", err)
+	}
+}
@@ -0,0 +1,80 @@
+package godistcache
+
+import (
+	"compress/gzip"
+	"fmt"
+	"io"
+
+	"github.com/klauspost/compress/s2"
+	"github.com/klauspost/compress/zstd"
+)
+
+// Identifies how a snapshot is compressed, recorded in its header so loading picks the right one
+type Compression uint8
+
+const (
+	CompressionNone Compression = 0 // Not compressed, the default
+	CompressionGzip Compression = 1 // compress/gzip, readable by almost anything
+	CompressionZstd Compression = 2 // Zstandard, the best ratio for its speed
+	CompressionS2   Compression = 3 // S2, the fastest with a lower ratio
+)
+
+// Returns the name of the compression
+func (c Compression) String() string {
+	switch c {
+	case CompressionNone:
+		return "none"
+	case CompressionGzip:
+		return "gzip"
+	case CompressionZstd:
+		return "zstd"
+	case CompressionS2:
+		return "s2"
+	}
+	return fmt.Sprintf("Compression(%d)", uint8(c))
+}
+
+// Returns a writer compressing into w, closing it flushes what's left but leaves w open
+// c -> The compression to use
+// w -> Where the compressed bytes go
+func compressWriter(c Compression, w io.Writer) (io.WriteCloser, error) {
+	switch c {
+	case CompressionNone:
+		return nopWriteCloser{w}, nil
+	case CompressionGzip:
+		return gzip.NewWriter(w), nil
+	case CompressionZstd:
+		return zstd.NewWriter(w)
+	case CompressionS2:
+		return s2.NewWriter(w), nil
+	}
+	return nil, fmt.Errorf("godistcache: unknown compression %v", c)
+}
+
+// Returns a reader decompressing r, closing it releases its resources but leaves r open
+// c -> The compression r was written with
+// r -> The compressed bytes
+func decompressReader(c Compression, r io.Reader) (io.ReadCloser, error) {
+	switch c {
+	case CompressionNone:
+		return io.NopCloser(r), nil
+	case CompressionGzip:
+		return gzip.NewReader(r)
+	case CompressionZstd:
+		d, err := zstd.NewReader(r, zstd.WithDecoderConcurrency(1))
+		if err != nil {
+			return nil, err
+		}
+		return d.IOReadCloser(), nil
+	case CompressionS2:
+		return io.NopCloser(s2.NewReader(r)), nil
+	}
+	return nil, fmt.Errorf("unsupported compression %v", c)
+}
+
+// Turns a writer into a WriteCloser whose Close does nothing
+type nopWriteCloser struct {
+	io.Writer
+}
+
+func (nopWriteCloser) Close() error { return nil }
@@ -0,0 +1,268 @@
+package godistcache
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// The longest header a snapshot can start with, magic, version, codec, compression, created, entries, the instance ID and its checksum
+const maxSnapshotHeader = 8 + 3 + 8 + 8 + 2 + math.MaxUint16 + 4
+
+// Uploads the keys changed since the previous run as small delta objects between full snapshots
+// Deltas are named after the snapshot they apply to, so ones left over from an older snapshot are never applied to a newer one
+type deltaChain struct {
+	fullEvery int               // Every how many runs a full snapshot is uploaded
+	take      func() deltaBatch // Takes the changes since the previous call, set by the cache
+	base      time.Time         // When the snapshot the deltas apply to was created, zero before the first full upload
+	next      uint64            // The sequence number of the next delta, also how many were uploaded since the snapshot
+}
+
+// The changes taken from a cache for one upload
+type deltaBatch interface {
+	full() bool              // The changes can't be told apart by key, only a full snapshot holds them
+	empty() bool             // Nothing changed
+	write(w io.Writer) error // Encodes the changed keys with their current items as a delta
+	undo()                   // Gives the changes back after a failed upload, so the next run sends them
+}
+
+// What a delta object holds, the keys changed since the previous one
+type deltaFile[K comparable, I any] struct {
+	Puts    map[K]I // Keys stored or changed, with their item
+	Deletes []K     // Keys removed
+}
+
+// Returns the key of a delta in the backend
+// object -> The key of the snapshot, without the extension
+// base -> When the snapshot was created
+// seq -> The sequence number of the delta
+func deltaKey(object string, base time.Time, seq uint64) string {
+	return fmt.Sprintf("%v%020d%v", deltaPrefix(object, base), seq, fileExtension)
+}
+
+// Returns what the keys of the deltas of a snapshot start with, every delta of the object without a base
+// object -> The key of the snapshot, without the extension
+// base -> When the snapshot was created, zero for every snapshot
+func deltaPrefix(object string, base time.Time) string {
+	if base.IsZero() {
+		return object + ".delta."
+	}
+	return fmt.Sprintf("%v.delta.%020d.", object, base.UnixNano())
+}
+
+// Upload what changed since the previous run as a delta, or the whole cache when it's time for a full snapshot, must hold mu
+// ctx -> Cancels the upload
+// save -> The function that encodes the whole cache
+func (p *persister) persistDelta(ctx context.Context, save func(io.Writer) error) error {
+	d := p.deltas
+	batch := d.take()
+	if d.base.IsZero() || batch.full() || d.next+1 >= uint64(d.fullEvery) {
+		// The deltas are named after the snapshot, keep its header to know when it was created
+		var head headerRecorder
+		err := p.persistFull(ctx, func(w io.Writer) error {
+			return save(io.MultiWriter(w, &head))
+		})
+		if err != nil {
+			batch.undo()
+			return err
+		}
+		h, _, err := readSnapshotHeader(bufio.NewReader(bytes.NewReader(head.buf)))
+		if err != nil {
+			d.base = time.Time{}
+			return err
+		}
+		d.base, d.next = h.Created, 0
+		return p.pruneDeltas(ctx)
+	}
+	if batch.empty() {
+		return nil
+	}
+	if err := p.upload(ctx, batch.write, deltaKey(p.object, d.base, d.next)); err != nil {
+		batch.undo()
+		return err
+	}
+	d.next++
+	return nil
+}
+
+// Delete the deltas of snapshots older than the one just uploaded, which it already holds
+// Deltas of newer snapshots, uploaded by another instance sharing the key, are left alone
+// ctx -> Cancels the deletes
+func (p *persister) pruneDeltas(ctx context.Context) error {
+	prefix := deltaPrefix(p.object, time.Time{})
+	keys, err := p.backend.List(ctx, prefix)
+	if err != nil {
+		return fmt.Errorf("%w: %w", ErrBackendUnavailable, err)
+	}
+	var errs []error
+	for _, key := range keys {
+		base, _, ok := strings.Cut(strings.TrimPrefix(key, prefix), ".")
+		if n, err := strconv.ParseInt(base, 10, 64); !ok || err != nil || n >= p.deltas.base.UnixNano() {
+			continue
+		}
+		if err := p.backend.Delete(ctx, key); err != nil {
+			errs = append(errs, fmt.Errorf("%w: %w", ErrBackendUnavailable, err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// Keeps the start of a snapshot so its header can be read once it's written
+type headerRecorder struct {
+	buf []byte
+}
+
+func (h *headerRecorder) Write(p []byte) (int, error) {
+	if n := maxSnapshotHeader - len(h.buf); n > 0 {
+		h.buf = append(h.buf, p[:min(n, len(p))]...)
+	}
+	return len(p), nil
+}
+
+// Applies the deltas uploaded after a snapshot in order, while it's loaded from the backend
+// ctx -> Cancels the downloads
+// p -> Downloads the deltas
+// cacheKey -> The key of the snapshot in the backend, without the extension
+// unwrap -> Converts an item of a delta into its value, expiration and sliding expiration
+// retype -> Puts back the types codecs other than Gob lose, nil when they don't
+func deltaStep[K comparable, V any, I any](ctx context.Context, p *persister, cacheKey string, unwrap func(I) (V, int64, time.Duration), retype func(SnapshotHeader, map[K]I) error) loadStep[K, V] {
+	return func(h SnapshotHeader, items map[K]importedItem[V]) error {
+		// Files without a header are never followed by deltas
+		if h.Created.IsZero() {
+			return nil
+		}
+		keys, err := p.backend.List(ctx, deltaPrefix(cacheKey, h.Created))
+		if err != nil {
+			return fmt.Errorf("%w: %w", ErrBackendUnavailable, err)
+		}
+		for n, key := range keys {
+			// A gap means a later delta could depend on one that's gone
+			if key != deltaKey(cacheKey, h.Created, uint64(n)) {
+				return fmt.Errorf("%w: delta %v of %v is missing", ErrCorruptSnapshot, n, cacheKey)
+			}
+			if err := applyDelta(ctx, p, key, items, unwrap, retype); err != nil {
+				return fmt.Errorf("godistcache: loading %v: %w", key, err)
+			}
+		}
+		return nil
+	}
+}
+
+// Download a delta and apply it to the items
+// ctx -> Cancels the download
+// p -> Downloads the delta
+// key -> The key of the delta in the backend
+// items -> The items to change
+// unwrap -> Converts an item of the delta into its value, expiration and sliding expiration
+// retype -> Puts back the types codecs other than Gob lose, nil when they don't
+func applyDelta[K comparable, V any, I any](ctx context.Context, p *persister, key string, items map[K]importedItem[V], unwrap func(I) (V, int64, time.Duration), retype func(SnapshotHeader, map[K]I) error) error {
+	r, err := p.backend.Get(ctx, key)
+	if err != nil {
+		return fmt.Errorf("%w: %w", ErrBackendUnavailable, err)
+	}
+	defer r.Close()
+	var f deltaFile[K, I]
+	h, err := readSnapshot(bufio.NewReader(r), &f)
+	if err != nil {
+		return err
+	}
+	if retype != nil {
+		if err := retype(h, f.Puts); err != nil {
+			return err
+		}
+	}
+	for _, k := range f.Deletes {
+		delete(items, k)
+	}
+	for k, i := range f.Puts {
+		v, soft, slide := unwrap(i)
+		items[k] = importedItem[V]{v: v, soft: soft, slide: slide}
+	}
+	return nil
+}
+
+// Let the persister take the changes of the store, does nothing unless deltas are enabled
+// s -> The store whose changes are tracked
+// p -> The persister of the cache
+// wrap -> Converts a value, expiration and sliding expiration into the item saved in snapshots
+// snap -> How deltas are written
+func attachDeltas[K comparable, V any, I any](s *store[K, V], p *persister, wrap func(V, int64, time.Duration) I, snap snapshotConfig) {
+	if p.deltas == nil {
+		return
+	}
+	p.deltas.take = func() deltaBatch {
+		keys, cleared := s.takeDirty()
+		return &storeDelta[K, V, I]{s: s, keys: keys, cleared: cleared, wrap: wrap, snap: snap}
+	}
+}
+
+// Takes the keys changed since the previous call, and whether or not the store was cleared or replaced since
+func (s *store[K, V]) takeDirty() ([]K, bool) {
+	var keys []K
+	for _, sh := range s.shards {
+		sh.m.Lock()
+		for k := range sh.dirty {
+			keys = append(keys, k)
+		}
+		sh.dirty = make(map[K]struct{})
+		sh.m.Unlock()
+	}
+	return keys, s.cleared.Swap(false)
+}
+
+// The changes taken from a store
+type storeDelta[K comparable, V any, I any] struct {
+	s       *store[K, V]                    // The store they were taken from
+	keys    []K                             // The keys that changed
+	cleared bool                            // The store was cleared or replaced
+	wrap    func(V, int64, time.Duration) I // Converts an entry into the item saved in deltas
+	snap    snapshotConfig                  // How the delta is written
+}
+
+func (d *storeDelta[K, V, I]) full() bool {
+	return d.cleared
+}
+
+func (d *storeDelta[K, V, I]) empty() bool {
+	return len(d.keys) == 0
+}
+
+// Keys still in the store are saved with their current item, the others as deletes
+// The items are read from a consistent view of the store, like full snapshots
+func (d *storeDelta[K, V, I]) write(w io.Writer) error {
+	byShard := make(map[*shard[K, V]][]K)
+	for _, k := range d.keys {
+		sh := d.s.shardFor(k)
+		byShard[sh] = append(byShard[sh], k)
+	}
+	f := deltaFile[K, I]{Puts: make(map[K]I, len(d.keys))}
+	d.s.cut(func(sh *shard[K, V]) {
+		for _, k := range byShard[sh] {
+			if v, ok := sh.items[k]; ok {
+				f.Puts[k] = d.wrap(v.v, v.soft, v.slide)
+			} else {
+				f.Deletes = append(f.Deletes, k)
+			}
+		}
+	})
+	return writeSnapshot(w, d.snap.header(len(d.keys)), f)
+}
+
+func (d *storeDelta[K, V, I]) undo() {
+	for _, k := range d.keys {
+		sh := d.s.shardFor(k)
+		sh.m.Lock()
+		sh.touched(k)
+		sh.m.Unlock()
+	}
+	if d.cleared {
+		d.s.cleared.Store(true)
+	}
+}
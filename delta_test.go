@@ -0,0 +1,151 @@
+package godistcache
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/mbarreca/godistcache/storage"
+)
+
+// Returns the delta objects in the backend
+func deltaObjects(t *testing.T, backend storage.Backend) []string {
+	t.Helper()
+	keys, err := backend.List(context.Background(), "test.delta.")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return keys
+}
+
+func TestDeltaSnapshots(t *testing.T) {
+	ctx := context.Background()
+	backend := storage.NewMemory()
+	opts := []Option{WithBackend(backend), WithObjectKey("test"), WithDeltaSnapshots(3)}
+	c := newTestTyped[int, string](t, opts...)
+	for i := 0; i < 100; i++ {
+		c.Put(i, "value")
+	}
+	// The first run has nothing to build on
+	if err := c.p.persist(ctx, c.WriteSnapshot); err != nil {
+		t.Fatal(err)
+	}
+	if n := len(deltaObjects(t, backend)); n != 0 {
+		t.Fatalf("First run uploaded %v deltas", n)
+	}
+	c.Put(1, "changed")
+	c.Delete(2)
+	if err := c.p.persist(ctx, c.WriteSnapshot); err != nil {
+		t.Fatal(err)
+	}
+	// Nothing changed, nothing to upload
+	if err := c.p.persist(ctx, c.WriteSnapshot); err != nil {
+		t.Fatal(err)
+	}
+	if n := len(deltaObjects(t, backend)); n != 1 {
+		t.Fatalf("Expected 1 delta, got %v", n)
+	}
+	c.Put(3, "changed")
+	if err := c.p.persist(ctx, c.WriteSnapshot); err != nil {
+		t.Fatal(err)
+	}
+	// The base snapshot plus the deltas in order
+	c2, err := NewTypedFromBackend[int, string]("test", ctx, opts...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if v, _ := c2.Get(1); v != "changed" || c2.Exists(2) || c2.Count() != 99 {
+		t.Fatalf("Deltas weren't applied: %v, %v items", v, c2.Count())
+	}
+	if v, _ := c2.Get(3); v != "changed" {
+		t.Fatalf("Second delta wasn't applied: %v", v)
+	}
+	// Every third run is a full snapshot, which replaces the deltas
+	c.Put(4, "changed")
+	if err := c.p.persist(ctx, c.WriteSnapshot); err != nil {
+		t.Fatal(err)
+	}
+	if n := len(deltaObjects(t, backend)); n != 0 {
+		t.Fatalf("Full snapshot left %v deltas", n)
+	}
+	// So does a Clear, which can't be told as keys
+	c.Put(5, "changed")
+	if err := c.p.persist(ctx, c.WriteSnapshot); err != nil {
+		t.Fatal(err)
+	}
+	c.Clear()
+	c.Put(6, "new")
+	if err := c.p.persist(ctx, c.WriteSnapshot); err != nil {
+		t.Fatal(err)
+	}
+	if n := len(deltaObjects(t, backend)); n != 0 {
+		t.Fatalf("Clear was uploaded as a delta")
+	}
+	c3, err := NewTypedFromBackend[int, string]("test", ctx, opts...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !c3.Exists(6) || c3.Count() != 1 {
+		t.Fatalf("Clear wasn't uploaded: %v items", c3.Count())
+	}
+}
+
+func TestDeltaPruneKeepsNewerBases(t *testing.T) {
+	ctx := context.Background()
+	backend := storage.NewMemory()
+	c := newTestTyped[int, string](t, WithBackend(backend), WithObjectKey("test"), WithDeltaSnapshots(2))
+	c.Put(1, "value")
+	if err := c.p.persist(ctx, c.WriteSnapshot); err != nil {
+		t.Fatal(err)
+	}
+	// Another instance sharing the key has a snapshot of its own, an older one is gone with ours
+	older := deltaKey("test", c.p.deltas.base.Add(-time.Hour), 1)
+	newer := deltaKey("test", c.p.deltas.base.Add(time.Hour), 1)
+	for _, key := range []string{older, newer} {
+		if err := backend.Put(ctx, key, strings.NewReader("delta")); err != nil {
+			t.Fatal(err)
+		}
+	}
+	c.Put(2, "value")
+	if err := c.p.persist(ctx, c.WriteSnapshot); err != nil {
+		t.Fatal(err)
+	}
+	c.Put(3, "value")
+	if err := c.p.persist(ctx, c.WriteSnapshot); err != nil {
+		t.Fatal(err)
+	}
+	if keys := deltaObjects(t, backend); len(keys) != 1 || keys[0] != newer {
+		t.Fatalf("Expected only the newer base's delta, got %v", keys)
+	}
+}
+
+func TestDeltaSnapshotsCodec(t *testing.T) {
+	RegisterType[Object]("godistcache.Object")
+	ctx := context.Background()
+	backend := storage.NewMemory()
+	opts := []Option{WithBackend(backend), WithObjectKey("test"), WithDeltaSnapshots(10), WithCodec(CBOR)}
+	c, err := New(ctx, opts...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c.Put("a", Object{One: "a"})
+	if err := c.p.persist(ctx, c.WriteSnapshot); err != nil {
+		t.Fatal(err)
+	}
+	c.Put("b", Object{One: "b"})
+	if err := c.p.persist(ctx, c.WriteSnapshot); err != nil {
+		t.Fatal(err)
+	}
+	if n := len(deltaObjects(t, backend)); n != 1 {
+		t.Fatalf("Expected 1 delta, got %v", n)
+	}
+	// A cache without deltas still loads them
+	c2, err := NewFromBackend("test", ctx, WithBackend(backend))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if v, _ := c2.Get("b"); v != (Object{One: "b"}) {
+		t.Fatalf("Delta lost the type of a value: %#v", v)
+	}
+}
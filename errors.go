@@ -0,0 +1,24 @@
+package godistcache
+
+import "errors"
+
+var (
+	// The key isn't in the cache
+	ErrNotFound = errors.New("godistcache: key not found")
+	// The key was in the cache but its expiration has passed
+	ErrExpired = errors.New("godistcache: key expired")
+	// PutCrypt or GetCrypt was called on a cache without WithEncryptionKey
+	ErrEncryptionDisabled = errors.New("godistcache: encryption not set up")
+	// The key or IV passed to WithEncryptionKey has the wrong length
+	ErrInvalidEncryptionKey = errors.New("godistcache: AES key must be 32 characters and cipher IV must be 16")
+	// The value GetCrypt read isn't something PutCrypt stored with this key
+	ErrDecryptionFailed = errors.New("godistcache: value couldn't be decrypted")
+	// S3 isn't set up or couldn't be reached
+	ErrBackendUnavailable = errors.New("godistcache: backend unavailable")
+	// StartPersistence was called while persistence is already running
+	ErrPersistenceStarted = errors.New("godistcache: persistence already started")
+	// A snapshot is truncated, fails its checksums or uses a format this version can't read
+	ErrCorruptSnapshot = errors.New("godistcache: corrupt snapshot")
+	// A GetOrLoad loader panicked, returned to the callers waiting on it
+	ErrLoaderPanicked = errors.New("godistcache: loader panicked")
+)
@@ -0,0 +1,86 @@
+package godistcache
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"log/slog"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/mbarreca/godistcache/storage"
+)
+
+func TestCryptErrors(t *testing.T) {
+	c, err := New(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := c.PutCrypt("a", "secret"); !errors.Is(err, ErrEncryptionDisabled) {
+		t.Fatalf("PutCrypt without a key returned %v", err)
+	}
+	c, err = New(context.Background(), WithEncryptionKey("cWlW2XekajJmuZqwAFNJTXqJ28YjiiP1", "Jh0VdNhFATWOPxvM"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := c.GetCrypt("missing"); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("GetCrypt of a missing key returned %v", err)
+	}
+	c.PutExp("expired", "value", -1)
+	if _, err := c.GetCrypt("expired"); !errors.Is(err, ErrExpired) {
+		t.Fatalf("GetCrypt of an expired key returned %v", err)
+	}
+	c.Put("plain", 1)
+	if _, err := c.GetCrypt("plain"); !errors.Is(err, ErrDecryptionFailed) {
+		t.Fatalf("GetCrypt of an unencrypted value returned %v", err)
+	}
+	c.Put("garbage", "bm90IGVuY3J5cHRlZA==")
+	if _, err := c.GetCrypt("garbage"); !errors.Is(err, ErrDecryptionFailed) {
+		t.Fatalf("GetCrypt of an invalid value returned %v", err)
+	}
+	if _, err := New(context.Background(), WithEncryptionKey("short", "Jh0VdNhFATWOPxvM")); !errors.Is(err, ErrInvalidEncryptionKey) {
+		t.Fatalf("New with a short key returned %v", err)
+	}
+}
+
+func TestBackendUnavailable(t *testing.T) {
+	c, err := New(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := c.StartPersistence(context.Background(), time.Minute); !errors.Is(err, ErrBackendUnavailable) {
+		t.Fatalf("StartPersistence without S3 returned %v", err)
+	}
+	if _, err := NewTypedFromS3[string, int]("cache", context.Background()); !errors.Is(err, ErrBackendUnavailable) {
+		t.Fatalf("NewTypedFromS3 without S3 returned %v", err)
+	}
+}
+
+func TestPersistErrors(t *testing.T) {
+	var logs bytes.Buffer
+	var handled error
+	o := newOptions([]Option{
+		WithLogger(slog.New(slog.NewTextHandler(&logs, nil))),
+		WithErrorHandler(func(err error) { handled = err }),
+	})
+	p, err := newPersister(context.Background(), o)
+	if err != nil {
+		t.Fatal(err)
+	}
+	errSave := errors.New("disk full")
+	p.backend = storage.NewMemory()
+	if err := p.persist(context.Background(), func(io.Writer) error { return errSave }); !errors.Is(err, errSave) {
+		t.Fatalf("Persist didn't return the save error: %v", err)
+	}
+	p.report(errSave)
+	if handled != errSave || !strings.Contains(logs.String(), "disk full") {
+		t.Fatalf("Failure wasn't reported, handler got %v, log was %q", handled, logs.String())
+	}
+	// Saving to a directory that doesn't exist fails instead of being ignored
+	c := newTestTyped[string, int](t)
+	if err := c.SaveToBinaryFile(t.TempDir() + "/missing/cache"); err == nil {
+		t.Fatalf("SaveToBinaryFile didn't return the write error")
+	}
+}
@@ -0,0 +1,99 @@
+package godistcache
+
+// Tells you why an item left the cache
+type EvictReason int
+
+const (
+	EvictDeleted  EvictReason = iota // Removed by Delete or DeleteSafe
+	EvictExpired                     // Its expiration passed, found by a read or the janitor
+	EvictCapacity                    // Evicted to stay within WithMaxEntries or WithMaxBytes
+	EvictCleared                     // Removed by Clear, or replaced by loading a file
+)
+
+// Returns the name of the reason
+func (r EvictReason) String() string {
+	switch r {
+	case EvictDeleted:
+		return "deleted"
+	case EvictExpired:
+		return "expired"
+	case EvictCapacity:
+		return "capacity"
+	case EvictCleared:
+		return "cleared"
+	}
+	return "unknown"
+}
+
+// The callbacks registered on a store, replaced as a whole so they can be read without a lock
+type hooks[K comparable, V any] struct {
+	evicted  func(key K, value V, reason EvictReason)
+	inserted func(key K, value V)
+	updated  func(key K, old, value V)
+	refresh  Refresher[K, V]
+}
+
+// An item that left the store, collected under the lock and reported after it's released
+type removal[K comparable, V any] struct {
+	key    K
+	v      V
+	reason EvictReason
+}
+
+// An item that was stored, collected under the lock and reported after it's released
+type insertion[K comparable, V any] struct {
+	key    K
+	old    V
+	v      V
+	update bool // The key already existed, old holds the previous value
+}
+
+// Returns the registered callbacks, never nil
+func (s *store[K, V]) callbacks() *hooks[K, V] {
+	return s.hooks.Load()
+}
+
+// Registers callbacks by copying the current ones and changing the copy
+// set -> Changes the copy
+func (s *store[K, V]) setHooks(set func(h *hooks[K, V])) {
+	s.hm.Lock()
+	defer s.hm.Unlock()
+	h := *s.callbacks()
+	set(&h)
+	s.hooks.Store(&h)
+}
+
+// Count the capacity evictions and report every removal to the callbacks, must not hold a lock
+// removed -> The items that left the store
+func (s *store[K, V]) reportRemovals(removed []removal[K, V]) {
+	if len(removed) == 0 {
+		return
+	}
+	h := s.callbacks()
+	for _, r := range removed {
+		if r.reason == EvictCapacity {
+			total := s.evictions.Add(1)
+			if s.onEvict != nil {
+				s.onEvict(r.key, total)
+			}
+		}
+		if h.evicted != nil {
+			h.evicted(r.key, r.v, r.reason)
+		}
+	}
+}
+
+// Report stored items to the callbacks, must not hold a lock
+// stored -> The items that were inserted or updated
+func (s *store[K, V]) reportInsertions(stored ...insertion[K, V]) {
+	h := s.callbacks()
+	for _, i := range stored {
+		if i.update {
+			if h.updated != nil {
+				h.updated(i.key, i.old, i.v)
+			}
+		} else if h.inserted != nil {
+			h.inserted(i.key, i.v)
+		}
+	}
+}
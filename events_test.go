@@ -0,0 +1,79 @@
+package godistcache
+
+import (
+	"context"
+	"os"
+	"testing"
+)
+
+func TestEventCallbacks(t *testing.T) {
+	c, err := New(context.Background(), WithMaxEntries(2))
+	if err != nil {
+		t.Fatal(err)
+	}
+	reasons := make(map[string]EvictReason)
+	inserted, updated := 0, 0
+	c.OnEvicted(func(key string, value any, reason EvictReason) {
+		reasons[key] = reason
+		// Callbacks run outside the lock, so calling back in can't deadlock
+		c.Exists(key)
+	})
+	c.OnInserted(func(key string, value any) { inserted++ })
+	c.OnUpdated(func(key string, old, value any) {
+		if old != 1 || value != 2 {
+			t.Errorf("Update reported the wrong values: %v, %v", old, value)
+		}
+		updated++
+	})
+
+	c.Put("a", 1)
+	c.Put("a", 2)
+	c.Put("b", 1)
+	c.Put("c", 1)
+	if inserted != 3 || updated != 1 || reasons["a"] != EvictCapacity {
+		t.Fatalf("Inserted: %v, Updated: %v, Reasons: %v", inserted, updated, reasons)
+	}
+	c.Delete("b")
+	if r, ok := reasons["b"]; !ok || r != EvictDeleted {
+		t.Fatalf("Delete wasn't reported: %v", reasons)
+	}
+	c.PutExp("d", 1, -1)
+	c.Get("d")
+	if r, ok := reasons["d"]; !ok || r != EvictExpired {
+		t.Fatalf("Expiry wasn't reported: %v", reasons)
+	}
+	c.Clear()
+	if r, ok := reasons["c"]; !ok || r != EvictCleared {
+		t.Fatalf("Clear wasn't reported: %v", reasons)
+	}
+}
+
+func TestEventCallbacksLoad(t *testing.T) {
+	c := newTestTyped[string, int](t)
+	c.Put("a", 1)
+	pwd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	fpwd := pwd + "/eventstest"
+	if err := c.SaveToBinaryFile(fpwd); err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(fpwd + ".godistcache")
+
+	c2 := newTestTyped[string, int](t)
+	c2.Put("b", 2)
+	var cleared, loaded []string
+	c2.OnEvicted(func(key string, value int, reason EvictReason) {
+		if reason == EvictCleared {
+			cleared = append(cleared, key)
+		}
+	})
+	c2.OnInserted(func(key string, value int) { loaded = append(loaded, key) })
+	if err := c2.LoadFromBinary(fpwd); err != nil {
+		t.Fatal(err)
+	}
+	if len(cleared) != 1 || cleared[0] != "b" || len(loaded) != 1 || loaded[0] != "a" {
+		t.Fatalf("Cleared: %v, Loaded: %v", cleared, loaded)
+	}
+}
@@ -0,0 +1,146 @@
+package godistcache
+
+import (
+	"container/heap"
+	"container/list"
+)
+
+// Decides which item gets evicted once the cache reaches its maximum amount of entries
+// The cache serializes every call, so implementations don't need to be safe for concurrent use
+type EvictionPolicy interface {
+	Add(key any)         // A new key was stored in the cache
+	Access(key any)      // An existing key was read or overwritten
+	Remove(key any)      // A key was removed from the cache
+	Victim() (any, bool) // The next key to evict, false if there is none
+}
+
+/*
+LRU - Least Recently Used
+*/
+type lru struct {
+	l    *list.List
+	keys map[any]*list.Element
+}
+
+// Creates a new policy that evicts the least recently used key
+func NewLRU() EvictionPolicy {
+	return &lru{l: list.New(), keys: make(map[any]*list.Element)}
+}
+
+func (p *lru) Add(key any) {
+	p.keys[key] = p.l.PushFront(key)
+}
+
+func (p *lru) Access(key any) {
+	if e, ok := p.keys[key]; ok {
+		p.l.MoveToFront(e)
+	}
+}
+
+func (p *lru) Remove(key any) {
+	if e, ok := p.keys[key]; ok {
+		p.l.Remove(e)
+		delete(p.keys, key)
+	}
+}
+
+func (p *lru) Victim() (any, bool) {
+	e := p.l.Back()
+	if e == nil {
+		return nil, false
+	}
+	return e.Value, true
+}
+
+/*
+FIFO - First In First Out
+*/
+type fifo struct {
+	lru
+}
+
+// Creates a new policy that evicts the oldest inserted key, reads don't affect the order
+func NewFIFO() EvictionPolicy {
+	return &fifo{lru{l: list.New(), keys: make(map[any]*list.Element)}}
+}
+
+func (p *fifo) Access(key any) {}
+
+/*
+LFU - Least Frequently Used
+*/
+type lfu struct {
+	h    lfuHeap
+	keys map[any]*lfuItem
+	tick uint64 // Breaks ties between equal frequencies, oldest access loses
+}
+
+type lfuItem struct {
+	key   any
+	freq  uint64
+	tick  uint64
+	index int
+}
+
+// Creates a new policy that evicts the least frequently used key, ties go to the least recently used
+func NewLFU() EvictionPolicy {
+	return &lfu{keys: make(map[any]*lfuItem)}
+}
+
+func (p *lfu) Add(key any) {
+	p.tick++
+	i := &lfuItem{key: key, freq: 1, tick: p.tick}
+	p.keys[key] = i
+	heap.Push(&p.h, i)
+}
+
+func (p *lfu) Access(key any) {
+	if i, ok := p.keys[key]; ok {
+		p.tick++
+		i.freq++
+		i.tick = p.tick
+		heap.Fix(&p.h, i.index)
+	}
+}
+
+func (p *lfu) Remove(key any) {
+	if i, ok := p.keys[key]; ok {
+		heap.Remove(&p.h, i.index)
+		delete(p.keys, key)
+	}
+}
+
+func (p *lfu) Victim() (any, bool) {
+	if len(p.h) == 0 {
+		return nil, false
+	}
+	return p.h[0].key, true
+}
+
+// Min-heap ordered by frequency, then by last access
+type lfuHeap []*lfuItem
+
+func (h lfuHeap) Len() int { return len(h) }
+func (h lfuHeap) Less(i, j int) bool {
+	if h[i].freq == h[j].freq {
+		return h[i].tick < h[j].tick
+	}
+	return h[i].freq < h[j].freq
+}
+func (h lfuHeap) Swap(i, j int) {
+	h[i], h[j] = h[j], h[i]
+	h[i].index = i
+	h[j].index = j
+}
+func (h *lfuHeap) Push(x any) {
+	i := x.(*lfuItem)
+	i.index = len(*h)
+	*h = append(*h, i)
+}
+func (h *lfuHeap) Pop() any {
+	old := *h
+	i := old[len(old)-1]
+	old[len(old)-1] = nil
+	*h = old[:len(old)-1]
+	return i
+}
@@ -0,0 +1,84 @@
+package godistcache
+
+import (
+	"context"
+	"strconv"
+	"testing"
+)
+
+func TestEvictionLRU(t *testing.T) {
+	var evicted []any
+	c, err := New(context.Background(), WithMaxEntries(3), WithEvictionCallback(func(key any, total uint64) {
+		evicted = append(evicted, key)
+	}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	c.Put("a", 1)
+	c.Put("b", 2)
+	c.Put("c", 3)
+	// Reading "a" makes "b" the least recently used
+	c.Get("a")
+	c.Put("d", 4)
+	if c.Count() != 3 || c.Exists("b") || !c.Exists("a") {
+		t.Fatalf("LRU evicted the wrong item: %v", evicted)
+	}
+	if c.Evictions() != 1 || len(evicted) != 1 || evicted[0] != "b" {
+		t.Fatalf("Eviction wasn't reported, count: %v, callback: %v", c.Evictions(), evicted)
+	}
+	// Overwriting doesn't evict
+	c.Put("d", 5)
+	if c.Evictions() != 1 {
+		t.Fatalf("Overwriting an item caused an eviction")
+	}
+}
+
+func TestEvictionLFU(t *testing.T) {
+	c := newTestTyped[string, int](t, WithMaxEntries(3), WithEvictionPolicy(NewLFU))
+	c.Put("a", 1)
+	c.Put("b", 2)
+	c.Put("c", 3)
+	c.Get("a")
+	c.Get("a")
+	c.Get("c")
+	c.Put("d", 4)
+	if c.Exists("b") || !c.Exists("a") || !c.Exists("c") {
+		t.Fatalf("LFU evicted the wrong item")
+	}
+	// "d" has been used the least now
+	c.Put("e", 5)
+	if c.Exists("d") {
+		t.Fatalf("LFU evicted the wrong item")
+	}
+}
+
+func TestEvictionFIFO(t *testing.T) {
+	c := newTestTyped[string, int](t, WithMaxEntries(2), WithEvictionPolicy(NewFIFO))
+	c.Put("a", 1)
+	c.Put("b", 2)
+	// Reads don't matter for FIFO
+	c.Get("a")
+	c.Put("c", 3)
+	if c.Exists("a") || !c.Exists("b") {
+		t.Fatalf("FIFO evicted the wrong item")
+	}
+}
+
+func TestEvictionBound(t *testing.T) {
+	c := newTestTyped[string, int](t, WithMaxEntries(100))
+	for i := 0; i < amountOfRuns; i++ {
+		c.Put(strconv.Itoa(i), i)
+		c.Delete(strconv.Itoa(i / 2))
+	}
+	if c.Count() > 100 {
+		t.Fatalf("Cache grew past its bound: %v", c.Count())
+	}
+	c.Clear()
+	before := c.Evictions()
+	for i := 0; i < amountOfRuns; i++ {
+		c.Put(strconv.Itoa(i), i)
+	}
+	if c.Count() != 100 || c.Evictions()-before != uint64(amountOfRuns-100) {
+		t.Fatalf("Count: %v, Evictions: %v", c.Count(), c.Evictions())
+	}
+}
@@ -0,0 +1,190 @@
+package godistcache
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"reflect"
+	"strings"
+	"time"
+)
+
+// Changes what ExportJSON writes and what ImportJSON loads
+type JSONOption func(*jsonOptions)
+
+// The settings a JSONOption can change
+type jsonOptions struct {
+	skipExpired bool   // Leave out items whose expiration has passed
+	prefix      string // Only keep keys starting with it
+	merge       bool   // ImportJSON adds to the cache instead of replacing it
+}
+
+// Leave out items whose expiration has passed
+func JSONSkipExpired() JSONOption {
+	return func(o *jsonOptions) {
+		o.skipExpired = true
+	}
+}
+
+// Only keep items whose key starts with the prefix, keys that aren't strings are compared with fmt.Sprint
+// prefix -> The prefix to match
+func JSONKeyPrefix(prefix string) JSONOption {
+	return func(o *jsonOptions) {
+		o.prefix = prefix
+	}
+}
+
+// Make ImportJSON add the items to the cache, overwriting keys it already has, instead of replacing the whole cache
+func JSONMerge() JSONOption {
+	return func(o *jsonOptions) {
+		o.merge = true
+	}
+}
+
+// One line of an export
+type jsonLine[K comparable, V any] struct {
+	Key       K          `json:"key"`
+	Value     V          `json:"value"`
+	Type      string     `json:"type,omitempty"`    // The name registered with RegisterType, only for Cache values
+	ExpiresAt *time.Time `json:"expires_at"`        // null never expires
+	Sliding   string     `json:"sliding,omitempty"` // How far each read moves the expiration, as a Go duration
+}
+
+// Tells you whether or not the options keep the key
+// key -> The key of the item
+func (o jsonOptions) keep(key any) bool {
+	if o.prefix == "" {
+		return true
+	}
+	s, ok := key.(string)
+	if !ok {
+		s = fmt.Sprint(key)
+	}
+	return strings.HasPrefix(s, o.prefix)
+}
+
+// Write every item of the store as a line of JSON
+// s -> The store to export
+// w -> Where the lines are written
+// typeOf -> Returns the registered type name of a value, nil to leave it out
+// opts -> Filters what gets exported
+func exportJSON[K comparable, V any](s *store[K, V], w io.Writer, typeOf func(V) string, opts []JSONOption) error {
+	var o jsonOptions
+	for _, opt := range opts {
+		opt(&o)
+	}
+	// Copy the store first, so a slow writer doesn't hold up the cache
+	var lines []jsonLine[K, V]
+	s.cut(func(sh *shard[K, V]) {
+		for k, v := range sh.items {
+			if !o.keep(k) || (o.skipExpired && s.isExpired(v.soft)) {
+				continue
+			}
+			line := jsonLine[K, V]{Key: k, Value: v.v}
+			if v.soft != noExpiry {
+				t := time.Unix(0, v.soft).UTC()
+				line.ExpiresAt = &t
+			}
+			if v.slide > 0 {
+				line.Sliding = v.slide.String()
+			}
+			lines = append(lines, line)
+		}
+	})
+	bw := bufio.NewWriter(w)
+	enc := json.NewEncoder(bw)
+	for _, line := range lines {
+		if typeOf != nil {
+			line.Type = typeOf(line.Value)
+		}
+		if err := enc.Encode(line); err != nil {
+			return fmt.Errorf("godistcache: exporting %v: %w", line.Key, err)
+		}
+	}
+	return bw.Flush()
+}
+
+// An item read by importJSON
+type importedItem[V any] struct {
+	v     V
+	soft  int64
+	slide time.Duration
+}
+
+// Read lines of JSON written by exportJSON into the store
+// Nothing is changed unless every line could be read
+// s -> The store to import into
+// r -> Where the lines are read from
+// decode -> Decodes the value of a line given the name of its type
+// opts -> Filters what gets imported and whether it replaces the store
+func importJSON[K comparable, V any](s *store[K, V], r io.Reader, decode func(json.RawMessage, string) (V, error), opts []JSONOption) error {
+	var o jsonOptions
+	for _, opt := range opts {
+		opt(&o)
+	}
+	m := make(map[K]importedItem[V])
+	dec := json.NewDecoder(bufio.NewReader(r))
+	for n := 1; ; n++ {
+		var line jsonLine[K, json.RawMessage]
+		if err := dec.Decode(&line); errors.Is(err, io.EOF) {
+			break
+		} else if err != nil {
+			return fmt.Errorf("godistcache: importing line %v: %w", n, err)
+		}
+		i := importedItem[V]{soft: noExpiry}
+		if line.ExpiresAt != nil {
+			i.soft = line.ExpiresAt.UnixNano()
+		}
+		if !o.keep(line.Key) || (o.skipExpired && s.isExpired(i.soft)) {
+			continue
+		}
+		if line.Sliding != "" {
+			slide, err := time.ParseDuration(line.Sliding)
+			if err != nil {
+				return fmt.Errorf("godistcache: importing line %v: %w", n, err)
+			}
+			i.slide = slide
+		}
+		v, err := decode(line.Value, line.Type)
+		if err != nil {
+			return fmt.Errorf("godistcache: importing line %v: %w", n, err)
+		}
+		i.v = v
+		m[line.Key] = i
+	}
+	if !o.merge {
+		restore(s, m, unwrapImported[V])
+		return nil
+	}
+	for k, i := range m {
+		s.putAt(k, i.v, i.soft, i.slide)
+	}
+	return nil
+}
+
+// Decode a value exported from a Cache into the type registered under name, or a plain JSON value without one
+// raw -> The value
+// name -> The name of its type
+func decodeJSONAny(raw json.RawMessage, name string) (any, error) {
+	if t, ok := registeredType(name); ok {
+		p := reflect.New(t)
+		if err := json.Unmarshal(raw, p.Interface()); err != nil {
+			return nil, fmt.Errorf("decoding a %v: %w", name, err)
+		}
+		return p.Elem().Interface(), nil
+	}
+	var v any
+	err := json.Unmarshal(raw, &v)
+	return v, err
+}
+
+// Decode a value exported from a TypedCache
+// raw -> The value
+// name -> Unused, TypedCache values always have the type V
+func decodeJSON[V any](raw json.RawMessage, name string) (V, error) {
+	var v V
+	err := json.Unmarshal(raw, &v)
+	return v, err
+}
@@ -0,0 +1,111 @@
+package godistcache
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/mbarreca/godistcache/godistcachetest"
+)
+
+func TestExportImportJSON(t *testing.T) {
+	RegisterType[Object]("godistcache.Object")
+	clock := godistcachetest.NewClock(time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC))
+	c, err := New(context.Background(), WithClock(clock))
+	if err != nil {
+		t.Fatal(err)
+	}
+	obj := Object{One: "One", Two: 2, Three: 3.5}
+	c.Put("user:1", obj)
+	c.PutTTL("user:2", "expires", time.Minute)
+	c.PutSliding("session:1", 7, 3600)
+	c.PutTTL("user:3", "expired", -time.Second)
+	c.Put("raw", map[string]int{"a": 1})
+	var buf bytes.Buffer
+	if err := c.ExportJSON(&buf, JSONSkipExpired()); err != nil {
+		t.Fatal(err)
+	}
+	// One line per item, readable without the cache
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	if len(lines) != 4 {
+		t.Fatalf("Expected 4 lines, got %v: %q", len(lines), buf.String())
+	}
+	for _, l := range lines {
+		var line map[string]any
+		if err := json.Unmarshal([]byte(l), &line); err != nil {
+			t.Fatal(err)
+		}
+		if line["key"] == "user:2" && line["expires_at"] != "2024-03-01T12:01:00Z" {
+			t.Fatalf("Unexpected expiration: %v", line["expires_at"])
+		}
+		if line["key"] == "user:1" && (line["expires_at"] != nil || line["type"] != "godistcache.Object") {
+			t.Fatalf("Unexpected line: %v", l)
+		}
+	}
+
+	c2, err := New(context.Background(), WithClock(clock))
+	if err != nil {
+		t.Fatal(err)
+	}
+	c2.Put("other", 1)
+	if err := c2.ImportJSON(bytes.NewReader(buf.Bytes())); err != nil {
+		t.Fatal(err)
+	}
+	if c2.Exists("other") || c2.Count() != 4 {
+		t.Fatalf("Import didn't replace the cache: %v items", c2.Count())
+	}
+	if v, _ := c2.Get("user:1"); v != obj {
+		t.Fatalf("Registered type wasn't restored: %#v", v)
+	}
+	if v, _ := c2.Get("session:1"); v != 7 {
+		t.Fatalf("Basic type wasn't restored: %#v", v)
+	}
+	if v, _ := c2.Get("raw"); v.(map[string]any)["a"] != float64(1) {
+		t.Fatalf("Unregistered value should be a plain JSON value: %#v", v)
+	}
+	if ttl, _ := c2.TTL("user:2"); ttl != time.Minute {
+		t.Fatalf("Expiration wasn't restored: %v", ttl)
+	}
+	clock.Advance(30 * time.Minute)
+	c2.Get("session:1")
+	if ttl, _ := c2.TTL("session:1"); ttl != time.Hour {
+		t.Fatalf("Sliding expiration wasn't restored: %v", ttl)
+	}
+
+	// Merge with a prefix only adds the matching keys
+	c3, err := New(context.Background(), WithClock(clock))
+	if err != nil {
+		t.Fatal(err)
+	}
+	c3.Put("other", 1)
+	if err := c3.ImportJSON(bytes.NewReader(buf.Bytes()), JSONMerge(), JSONKeyPrefix("user:")); err != nil {
+		t.Fatal(err)
+	}
+	if !c3.Exists("other") || !c3.Exists("user:1") || c3.Exists("session:1") {
+		t.Fatalf("Merge import with a prefix loaded the wrong keys")
+	}
+}
+
+func TestTypedExportImportJSON(t *testing.T) {
+	c := newTestTyped[int, typedObject](t)
+	c.Put(1, typedObject{Name: "a", Tags: []string{"b"}})
+	c.Put(20, typedObject{Name: "c"})
+	var buf bytes.Buffer
+	if err := c.ExportJSON(&buf, JSONKeyPrefix("2")); err != nil {
+		t.Fatal(err)
+	}
+	c2 := newTestTyped[int, typedObject](t)
+	if err := c2.ImportJSON(&buf); err != nil {
+		t.Fatal(err)
+	}
+	if v, ok := c2.Get(20); !ok || v.Name != "c" || c2.Count() != 1 {
+		t.Fatalf("Typed import failed: %v, %v items", v, c2.Count())
+	}
+	// A bad line leaves the cache alone
+	if err := c2.ImportJSON(strings.NewReader("{\"key\":1,\"value\":{}}\nnot json")); err == nil || c2.Count() != 1 {
+		t.Fatalf("Broken import returned %v and left %v items", err, c2.Count())
+	}
+}
@@ -2,7 +2,12 @@ module github.com/mbarreca/godistcache
 
 go 1.23.1
 
-require github.com/minio/minio-go/v7 v7.0.80
+require (
+	github.com/fxamacker/cbor/v2 v2.7.0
+	github.com/klauspost/compress v1.17.11
+	github.com/minio/minio-go/v7 v7.0.80
+	github.com/vmihailenco/msgpack/v5 v5.4.1
+)
 
 require (
 	github.com/davecgh/go-spew v1.1.1 // indirect
@@ -10,11 +15,12 @@ require (
 	github.com/go-ini/ini v1.67.0 // indirect
 	github.com/goccy/go-json v0.10.3 // indirect
 	github.com/google/uuid v1.6.0 // indirect
-	github.com/klauspost/compress v1.17.11 // indirect
 	github.com/klauspost/cpuid/v2 v2.2.8 // indirect
 	github.com/minio/md5-simd v1.1.2 // indirect
 	github.com/pmezard/go-difflib v1.0.0 // indirect
 	github.com/rs/xid v1.6.0 // indirect
+	github.com/vmihailenco/tagparser/v2 v2.0.0 // indirect
+	github.com/x448/float16 v0.8.4 // indirect
 	golang.org/x/crypto v0.28.0 // indirect
 	golang.org/x/net v0.30.0 // indirect
 	golang.org/x/sys v0.26.0 // indirect
@@ -5,154 +5,139 @@ import (
 	"context"
 	"crypto/aes"
 	"crypto/cipher"
+	"crypto/rand"
 	"encoding/base64"
 	"encoding/gob"
 	"errors"
 	"fmt"
-	"os"
-	"sync"
+	"io"
+	"reflect"
 	"time"
-
-	"github.com/mbarreca/godistcache/storage"
 )
 
 // This is the main cache object
 type Cache struct {
-	m       sync.RWMutex         // Used to prevent collisions
-	items   map[string]CacheItem // Where the items are stored
-	s3      *storage.S3
-	exp     int64 // Default Expiration Time in Seconds
-	crypt   cipher.BlockMode
-	decrypt cipher.BlockMode
+	s     *store[string, any] // Where the items are stored
+	p     *persister          // Saves the cache to the backend
+	snap  snapshotConfig      // How snapshots are written
+	block cipher.Block        // The AES cipher of PutCrypt and GetCrypt, nil without a key
 }
 
 // This object is internally what exists in each item
 type CacheItem struct {
-	V interface{} // The item to store
-	E int64       // Expiration timestamp in Unix UTC
+	V     interface{}   // The item to store
+	E     int64         // Expiration timestamp in Unix UTC seconds, the only one in files saved before N existed
+	S     int64         // Sliding expiration in seconds, 0 is a fixed expiration, the only one in files saved before D existed
+	N     int64         // Expiration timestamp in Unix UTC nanoseconds, 0 in files saved before it existed
+	D     time.Duration // Sliding expiration, 0 is a fixed expiration or a file saved before it existed
+	Never bool          // The item never expires
+	T     string        // The name V's type was registered under with RegisterType, only saved by codecs other than Gob
 }
 
 // Creates a new cache
-// exp -> The time, in seconds that you want default expiration, 0 is never expire
 // ctx -> The context you want to provide for purposes of telemetry
-func New(exp int64, ctx context.Context) (*Cache, error) {
+// opts -> Optional settings such as WithDefaultTTL, WithS3 or FromEnv
+func New(ctx context.Context, opts ...Option) (*Cache, error) {
+	o := newOptions(opts)
+	if o.err != nil {
+		return nil, o.err
+	}
 	// Register the Cache Type with Gob
 	gob.Register(CacheItem{})
 
-	// Setup S3
-	s3, err := storage.New(ctx)
+	// Setup the backend
+	p, err := newPersister(ctx, o)
 	if err != nil {
-		// Soft-fail
-		fmt.Println(err)
-	}
-	// If "unlimited", set to 1000 years
-	if exp == 0 {
-		exp = 1000 * 365 * 24 * 60 * 60
+		return nil, err
 	}
 	// Check if Encryption is enabled
-	crypt, decrypt, err := getEncryptionObjects()
+	block, err := getEncryptionObjects(o.cipherKey, o.cipherIV)
 	if err != nil {
 		return nil, err
 	}
-	return &Cache{items: make(map[string]CacheItem), exp: exp, crypt: crypt, decrypt: decrypt, s3: s3}, nil
+	s := newStore[string, any](o.defaultTTL, o)
+	if err := attachWAL(s, p, o); err != nil {
+		s.stopJanitor()
+		return nil, err
+	}
+	c := &Cache{s: s, p: p, snap: newSnapshotConfig(o), block: block}
+	attachDeltas(s, p, c.wrap, c.snap)
+	return c, nil
 }
 
-// Creates a new cache from a file in S3
-// exp -> The time, in seconds that you want default expiration, 0 is never expire
-// cacheKey -> The key you use in your S3 store that we'll pull from - DO NOT include the .godistcache extension
+// Creates a new cache from a file in the backend
+// cacheKey -> The key of the file in the backend - DO NOT include the .godistcache extension
 // ctx -> The context you want to provide for purposes of telemetry
-func NewFromS3(exp int64, cacheKey string, ctx context.Context) (*Cache, error) {
-	// Register the Cache Type with Gob
-	gob.Register(CacheItem{})
-
-	// Create new S3 Object
-	s3, err := storage.New(ctx)
-	if err != nil {
-		return nil, err
-	}
-	// Download the File from the cache
-	filePath, err := s3.S3Download(cacheKey + ".godistcache")
-	if err != nil {
-		return nil, err
-	}
+// opts -> Optional settings, the backend has to be set up with WithBackend, WithS3 or FromEnv
+func NewFromBackend(cacheKey string, ctx context.Context, opts ...Option) (*Cache, error) {
 	// Create new cache
-	c, err := New(0, ctx)
+	c, err := New(ctx, opts...)
 	if err != nil {
 		return nil, err
 	}
-	// Load the entries in from the cache
-	if err := c.LoadFromBinary(filePath); err != nil {
-		return nil, err
-	}
-	// Delete the file and cleanup
-	if err := os.Remove(filePath + ".godistcache"); err != nil {
-		return nil, err
+	// Download the file and load the entries in from the cache, with the deltas uploaded since
+	err = c.p.load(ctx, cacheKey, func(r io.Reader) error {
+		return c.load(r, deltaStep(ctx, c.p, cacheKey, unwrapCacheItem, retypeItems), replayStep(c.s))
+	})
+	if err != nil {
+		// Nothing was started in the background yet, stop the janitor and the log without saving
+		c.s.stopJanitor()
+		return nil, errors.Join(err, c.s.wal.close())
 	}
 	return c, nil
 }
 
-// This will set up a goroutine on the interval you select
-// Interval - In seconds
-// filePath -> The path to store the temporary file, the name comes from the ENV Variable GODISTCACHE_S3_OBJECT
-func (c *Cache) SetupPersistToS3(interval int, filePath string) {
-	if c.s3 == nil {
-		panic("S3 isn't setup, can't setup persisting function")
-	}
-	for {
-		go setupPersistToS3(c, filePath)
-		time.Sleep(time.Duration(interval) * time.Second)
-	}
+// Creates a new cache from a file in S3, the same as NewFromBackend
+// Deprecated: Use NewFromBackend
+func NewFromS3(cacheKey string, ctx context.Context, opts ...Option) (*Cache, error) {
+	return NewFromBackend(cacheKey, ctx, opts...)
 }
 
-// Goroutine to save the file, then upload to S3
-// cache -> The cache you want to export
-// filePath -> The path to store the temporary file, the name comes from the ENV Variable GODISTCACHE_S3_OBJECT
-func setupPersistToS3(c *Cache, filePath string) {
-	// Export to a file
-	c.SaveToBinaryFile(filePath)
-	// Upload it to S3
-	c.s3.S3Upload(filePath, os.Getenv("GODISTCACHE_S3_OBJECT"))
-	// Delete the file and cleanup
-	if err := os.Remove(filePath + ".godistcache"); err != nil {
-		fmt.Println(err)
-	}
+// Start saving the cache to the backend every interval in the background, to the key set with WithObjectKey
+// A run never starts before the previous one is done, failures are logged and passed to WithErrorHandler
+// Returns ErrBackendUnavailable if there is no backend and ErrPersistenceStarted if it's already running
+// ctx -> Persistence stops once it's done
+// interval -> How long to wait between runs
+func (c *Cache) StartPersistence(ctx context.Context, interval time.Duration) error {
+	return c.p.start(ctx, interval, c.WriteSnapshot)
+}
+
+// Stop persistence and the janitor, then save the cache to the backend one last time if there is one and close the write-ahead log
+// The cache can still be used afterwards, but writes are no longer logged
+// A run in progress is canceled, Close waits for it and for the final save, so nothing reads the cache once it returns
+// ctx -> Cancels the final save, which then returns the context's error
+func (c *Cache) Close(ctx context.Context) error {
+	c.s.stopJanitor()
+	return errors.Join(c.p.close(ctx, c.WriteSnapshot), c.s.wal.close())
 }
 
 // Attempt to add an item to the cache
 // key -> The key to lookup in the cache
 // value -> The value to store in the cache
 func (c *Cache) Put(key string, value any) {
-	c.m.Lock()
-	c.items[key] = CacheItem{V: value, E: time.Now().UTC().Unix() + c.exp}
-	c.m.Unlock()
+	c.s.put(key, value, DefaultExpiration)
 }
 
-// Put an encrypted string in the cache
+// Put a string in the cache encrypted, older versions encrypted the key instead of the value
 // key -> The key to lookup in the cache
 // value -> The value to store in the cache
 func (c *Cache) PutCrypt(key, value string) error {
-	if c.crypt == nil {
-		return errors.New("Encryption not set up")
+	if c.block == nil {
+		return ErrEncryptionDisabled
 	}
-	v := c.encryptString(key)
-	c.m.Lock()
-	c.items[key] = CacheItem{V: v, E: time.Now().UTC().Unix() + c.exp}
-	c.m.Unlock()
+	c.s.put(key, c.encryptString(value), DefaultExpiration)
 	return nil
 }
 
 // Put an encrypted string in the cache with custom expiration
 // key -> The key to lookup in the cache
 // value -> The value to store in the cache
-// exp -> The expiration delay from now, in seconds
+// exp -> The expiration delay from now, in seconds, 0 uses the default expiration
 func (c *Cache) PutCryptExp(key, value string, exp int64) error {
-	if c.crypt == nil {
-		return errors.New("Encryption not set up")
+	if c.block == nil {
+		return ErrEncryptionDisabled
 	}
-	v := c.encryptString(key)
-	c.m.Lock()
-	c.items[key] = CacheItem{V: v, E: time.Now().UTC().Unix() + exp}
-	c.m.Unlock()
+	c.s.put(key, c.encryptString(value), secondsTTL(exp))
 	return nil
 }
 
@@ -160,201 +145,386 @@ func (c *Cache) PutCryptExp(key, value string, exp int64) error {
 // key -> The key to lookup in the cache
 // value -> The value to store in the cache
 func (c *Cache) PutExp(key string, value any, exp int64) {
-	c.m.Lock()
-	c.items[key] = CacheItem{V: value, E: time.Now().UTC().Unix() + exp}
-	c.m.Unlock()
+	c.s.put(key, value, secondsTTL(exp))
+}
+
+// Add an item with a time to live, with sub-second precision
+// key -> The key to lookup in the cache
+// value -> The value to store in the cache
+// ttl -> The time to live, DefaultExpiration uses the cache's and NoExpiration never expires
+func (c *Cache) PutTTL(key string, value any, ttl time.Duration) {
+	c.s.put(key, value, ttl)
+}
+
+// Add an item that expires at the given time
+// key -> The key to lookup in the cache
+// value -> The value to store in the cache
+// t -> When the item expires, the zero time never expires
+func (c *Cache) PutUntil(key string, value any, t time.Time) {
+	soft := noExpiry
+	if !t.IsZero() {
+		soft = t.UnixNano()
+	}
+	c.s.putAt(key, value, soft, 0)
 }
 
 // Add an item to the cache and send confirmation if successful, computationally more expensive (~10%)
 // key -> The key to lookup in the cache
 // value -> The value to store in the cache
 func (c *Cache) PutSafe(key string, value any) bool {
-	c.m.Lock()
-	c.items[key] = CacheItem{V: value, E: time.Now().UTC().Unix() + c.exp}
-	c.m.Unlock()
-	// See if it exists
-	valueNew, exists := c.Get(key)
-	if exists {
-		if value == valueNew {
-			return true
-		}
-	}
-	return false
+	// Set the item
+	c.s.put(key, value, DefaultExpiration)
+	return c.confirm(key, value)
 }
 
 // Add an item to the cache with custom expiration and send confirmation if successful. Computationally more expensive (~10%)
 // key -> The key to lookup in the cache
 // value -> The value to store in the cache
-// exp -> The expiration delay from now, in seconds
+// exp -> The expiration delay from now, in seconds, 0 uses the default expiration
 func (c *Cache) PutSafeExp(key string, value any, exp int64) bool {
 	// Set the item
-	c.m.Lock()
-	c.items[key] = CacheItem{V: value, E: time.Now().UTC().Unix() + exp}
-	c.m.Unlock()
+	c.s.put(key, value, secondsTTL(exp))
+	return c.confirm(key, value)
+}
+
+// Confirm an item was stored with the given value
+// key -> The key to lookup in the cache
+// value -> The value that should be stored
+func (c *Cache) confirm(key string, value any) bool {
+	// See if it exists
 	valueNew, exists := c.Get(key)
 	if exists {
-		if value == valueNew {
+		// Slices and maps can't be compared with ==
+		if reflect.DeepEqual(value, valueNew) {
 			return true
 		}
 	}
 	return false
 }
 
+// Add an item with a sliding expiration, every read moves its expiration exp seconds from now
+// key -> The key to lookup in the cache
+// value -> The value to store in the cache
+// exp -> The expiration delay from now and from every read, in seconds, 0 uses the default expiration
+func (c *Cache) PutSliding(key string, value any, exp int64) {
+	c.s.putSliding(key, value, secondsTTL(exp))
+}
+
 // Attempt to get an item from the cache. Will return the item and a bool to indicate success
 // key -> The key to lookup in the cache
 func (c *Cache) Get(key string) (any, bool) {
-	c.m.Lock()
-	v := c.items[key]
-	c.m.Unlock()
-	// Check if the entry exists
-	if v == (CacheItem{}) {
-		return nil, false
-	}
-	// Check if the key has expired, if so delete
-	if v.E < time.Now().UTC().Unix() {
-		c.Delete(key)
-		return nil, false
-	}
-	return v.V, true
+	return c.s.get(key)
 }
 
 // Attempt to get encrypted value from the cache. Will return the item and an error if unsuccessful
 // key -> The key to lookup in the cache
 func (c *Cache) GetCrypt(key string) (string, error) {
-	c.m.Lock()
-	v := c.items[key]
-	c.m.Unlock()
+	v, ok, expired := c.s.lookup(key)
+	// Check if the key has expired, expired keys are deleted by the lookup
+	if expired {
+		return "", ErrExpired
+	}
 	// Check if the entry exists
-	if v == (CacheItem{}) {
-		return "", errors.New("Entry doesn't exist")
+	if !ok {
+		return "", ErrNotFound
+	}
+	if c.block == nil {
+		return "", ErrEncryptionDisabled
 	}
-	// Check if the key has expired, if so delete
-	if v.E < time.Now().UTC().Unix() {
-		c.Delete(key)
-		return "", errors.New("Entry is expired")
+	str, ok := v.(string)
+	if !ok {
+		return "", ErrDecryptionFailed
 	}
-	val, err := c.decryptString(v.V.(string))
+	val, err := c.decryptString(str)
 	if err != nil {
 		return "", err
 	}
 	return val, nil
 }
 
+// Get an item from the cache, or load it on a miss and cache it with the TTL the loader returns
+// Concurrent misses for the same key share one loader call
+// ctx -> Passed to the loader, callers waiting on another caller's load stop when their own context is done
+// key -> The key to lookup in the cache
+// loader -> Loads the value on a miss, returns the value, its TTL (DefaultExpiration uses the cache's) and an error
+func (c *Cache) GetOrLoad(ctx context.Context, key string, loader Loader[any]) (any, error) {
+	return c.s.getOrLoad(ctx, key, loader)
+}
+
+// Move the expiration of an item without rewriting its value, returns false if it doesn't exist
+// Sliding items keep sliding by the new TTL
+// key -> The key to lookup in the cache
+// ttl -> The new time to live from now, DefaultExpiration uses the cache's and NoExpiration never expires
+func (c *Cache) Touch(key string, ttl time.Duration) bool {
+	return c.s.touch(key, ttl, false)
+}
+
+// Returns how long until an item expires, NoExpiration if it never does, and false if it doesn't exist
+// key -> The key to lookup in the cache
+func (c *Cache) TTL(key string) (time.Duration, bool) {
+	return c.s.ttl(key)
+}
+
+// Remove the expiration of an item so it's kept until deleted or evicted, returns false if it doesn't exist
+// key -> The key to lookup in the cache
+func (c *Cache) Persist(key string) bool {
+	return c.s.persist(key)
+}
+
 // Delete an item from the cache
 func (c *Cache) Delete(key string) {
-	c.m.Lock()
-	delete(c.items, key)
-	c.m.Unlock()
+	c.s.delete(key)
 }
 
-// Delete an item from the cache with a check for safety, will return true if successful
+// Delete an item from the cache with a check for safety, returns true if the item was in the cache and is now gone
+// Returns false for a key that didn't exist, older versions always returned false
 // key -> The key to lookup in the cache
 func (c *Cache) DeleteSafe(key string) bool {
-	c.m.Lock()
-	delete(c.items, key)
-	v := c.items[key]
-	c.m.Unlock()
-	if v == (CacheItem{}) {
-		return false
-	}
-	return true
+	return c.s.delete(key)
 }
 
 // Returns the amount of items in the cache
 func (c *Cache) Count() int {
-	count := len(c.items)
-	return count
+	return c.s.count()
+}
+
+// Returns the estimated size of all items in bytes, only tracked when WithMaxBytes or WithSizer is set
+func (c *Cache) Bytes() int64 {
+	return c.s.size()
+}
+
+// Returns the amount of items evicted because the cache reached its maximum amount of entries or bytes
+func (c *Cache) Evictions() uint64 {
+	return c.s.evictionCount()
 }
 
 // Tells you whether or not the item corresponding to the key exists
 // key -> The key to lookup in the cache
 func (c *Cache) Exists(key string) bool {
-	c.m.Lock()
-	val := c.items[key]
-	c.m.Unlock()
-	if val == (CacheItem{}) {
-		return false
-	}
-	return true
+	return c.s.exists(key)
+}
+
+// Register a callback for every item that leaves the cache, replaces the previous one
+// The callback runs outside the cache lock so it can safely call back into the cache
+// f -> Receives the key, the value and why it left
+func (c *Cache) OnEvicted(f func(key string, value any, reason EvictReason)) {
+	c.s.setHooks(func(h *hooks[string, any]) { h.evicted = f })
+}
+
+// Register a callback for every new key stored in the cache, replaces the previous one
+// The callback runs outside the cache lock so it can safely call back into the cache
+// f -> Receives the key and the value
+func (c *Cache) OnInserted(f func(key string, value any)) {
+	c.s.setHooks(func(h *hooks[string, any]) { h.inserted = f })
+}
+
+// Register a callback for every existing key that gets a new value, replaces the previous one
+// The callback runs outside the cache lock so it can safely call back into the cache
+// f -> Receives the key, the previous value and the new value
+func (c *Cache) OnUpdated(f func(key string, old, value any)) {
+	c.s.setHooks(func(h *hooks[string, any]) { h.updated = f })
+}
+
+// Register the function that refreshes stale items in the background, replaces the previous one
+// Used with WithStaleWhileRevalidate and WithRefreshAhead
+// f -> Reloads the value of a key, returns the value, its TTL (DefaultExpiration uses the cache's) and an error
+func (c *Cache) SetRefresher(f Refresher[string, any]) {
+	c.s.setHooks(func(h *hooks[string, any]) { h.refresh = f })
+}
+
+// Check every item and delete the expired ones, returns the amount deleted
+func (c *Cache) DeleteExpired() int {
+	return c.s.deleteExpired()
+}
+
+// Stop the background janitor started with WithJanitor, safe to call more than once
+func (c *Cache) Stop() {
+	c.s.stopJanitor()
 }
 
 // DANGEROUS - This will clear the cache
 func (c *Cache) Clear() {
-	c.m.Lock()
-	clear(c.items)
-	c.m.Unlock()
+	c.s.clear()
 }
 
 // This will convert the cache to a binary and save it to a file
-// IMPORTANT -> Make sure to register all your structs with Gob before saving
+// IMPORTANT -> Make sure to register all your structs with Gob before saving, or with RegisterType when using another codec
 // fileNamePath -> The path with the filename - DO NOT add the extension .godistcache
 func (c *Cache) SaveToBinaryFile(filePathName string) error {
-	var buf bytes.Buffer
-	enc := gob.NewEncoder(&buf)
-	if err := enc.Encode(c.items); err != nil {
-		return err
+	return checkpoint(c.p.log, func() error {
+		return writeBinaryFile(filePathName, c.snap.fileMode, c.snap.generations, c.WriteSnapshot)
+	})
+}
+
+// Encode the cache as a .godistcache file and stream it to w, nothing touches the disk
+// w -> Where the snapshot is written
+func (c *Cache) WriteSnapshot(w io.Writer) error {
+	m := snapshot(c.s, c.wrap)
+	return writeSnapshot(w, c.snap.header(len(m)), m)
+}
+
+// Converts a value of the cache into the item saved in snapshots
+// v -> The value
+// soft -> The expiration in Unix UTC nanoseconds
+// slide -> The sliding expiration
+func (c *Cache) wrap(v any, soft int64, slide time.Duration) CacheItem {
+	e, n, never := encodeExpiry(soft)
+	i := CacheItem{V: v, E: e, S: int64(slide / time.Second), N: n, D: slide, Never: never}
+	// Gob keeps the types of values itself
+	if c.snap.codec.ID() != CodecGob {
+		i.T = typeName(v)
 	}
-	// Check to see if the file exists
-	if _, err := os.Stat(filePathName + ".godistcache"); err == nil {
-		// If the file exists, delete it
-		if err := os.Remove(filePathName + ".godistcache"); err != nil {
-			return err
+	return i
+}
+
+// Returns the value, expiration and sliding expiration of an item saved in a snapshot
+func unwrapCacheItem(i CacheItem) (any, int64, time.Duration) {
+	return i.V, decodeExpiry(i.E, i.N, i.Never), decodeSlide(i.S, i.D)
+}
+
+// Other codecs decode values without their types, put them back
+// h -> The header of the file the items come from
+// m -> The decoded items, changed in place
+func retypeItems(h SnapshotHeader, m map[string]CacheItem) error {
+	codec, ok := lookupCodec(h.Codec)
+	if !ok || h.Codec == CodecGob {
+		return nil
+	}
+	for k, i := range m {
+		v, err := decodeTyped(codec, i.T, i.V)
+		if err != nil {
+			return fmt.Errorf("%w: %q: %w", ErrCorruptSnapshot, k, err)
 		}
+		i.V = v
+		m[k] = i
 	}
-	// Write the file
-	os.WriteFile(filePathName+".godistcache", buf.Bytes(), os.ModePerm)
 	return nil
 }
 
 // This will load any .godistcache file into your cache
 // filePathName -> The path with the filename - DO NOT add the extension .godistcache
-// IMPORTANT -> Make sure to register all your structs with Gob before loading
+// IMPORTANT -> Make sure to register all your structs with Gob before loading, or with RegisterType when using another codec
 func (c *Cache) LoadFromBinary(filePathName string) error {
-	// Open the file
-	file, err := os.ReadFile(filePathName + ".godistcache")
+	return loadBinaryFile(filePathName, c.replayLoad)
+}
+
+// Decode a .godistcache file streamed from r and replace the cache with its items
+// Nothing is replaced unless the whole file passes its checksums
+// r -> Where the snapshot is read from
+func (c *Cache) ReadSnapshot(r io.Reader) error {
+	return c.load(r)
+}
+
+// Decode a .godistcache file and replay the write-ahead log on top of it, then replace the cache with the result
+// r -> Where the snapshot is read from
+func (c *Cache) replayLoad(r io.Reader) error {
+	return c.load(r, replayStep(c.s))
+}
+
+// Stop loads from replaying the write-ahead log left by the previous run on top of their snapshot
+// The cache already starts from the log when it's created, so there is nothing else to replay
+// Deprecated: Not needed anymore, the log is replayed when the cache is created
+func (c *Cache) ReplayWAL() error {
+	return replayWAL(c.s)
+}
+
+// Decode a .godistcache file and replace the cache with its items
+// r -> Where the snapshot is read from
+// steps -> Change the decoded items before they replace the cache
+func (c *Cache) load(r io.Reader, steps ...loadStep[string, any]) error {
+	m := make(map[string]CacheItem)
+	h, err := readSnapshot(r, &m)
 	if err != nil {
 		return err
 	}
-	// Create the buffer
-	buf := bytes.NewBuffer(file)
-	dec := gob.NewDecoder(buf)
+	if err := retypeItems(h, m); err != nil {
+		return err
+	}
+	return restoreWith(c.s, h, m, unwrapCacheItem, steps...)
+}
+
+// Load a .godistcache file into the cache without dropping what it already holds
+// Keys the cache already has keep their item unless MergeWith or MergeResolver says otherwise
+// filePathName -> The path with the filename - DO NOT add the extension .godistcache
+// opts -> MergeWith or MergeResolver to settle conflicts, MergeSkipExpired to leave out expired items
+func (c *Cache) MergeFromBinary(filePathName string, opts ...MergeOption) error {
+	return loadBinaryFile(filePathName, func(r io.Reader) error {
+		return c.merge(r, opts)
+	})
+}
+
+// Download a cache file from the backend, with the deltas uploaded since, and load it into the cache without dropping what it already holds
+// ctx -> Cancels the download
+// cacheKey -> The key of the file in the backend - DO NOT include the .godistcache extension
+// opts -> MergeWith or MergeResolver to settle conflicts, MergeSkipExpired to leave out expired items
+func (c *Cache) MergeFromBackend(ctx context.Context, cacheKey string, opts ...MergeOption) error {
+	return c.p.load(ctx, cacheKey, func(r io.Reader) error {
+		return c.merge(r, opts, deltaStep(ctx, c.p, cacheKey, unwrapCacheItem, retypeItems))
+	})
+}
+
+// Merge a cache file from S3 into the cache, the same as MergeFromBackend
+func (c *Cache) MergeFromS3(ctx context.Context, cacheKey string, opts ...MergeOption) error {
+	return c.MergeFromBackend(ctx, cacheKey, opts...)
+}
+
+// Decode a .godistcache file and merge its items into the cache
+// r -> Where the snapshot is read from
+// opts -> How conflicts are settled and which items are left out
+// steps -> Change the decoded items before they are merged
+func (c *Cache) merge(r io.Reader, opts []MergeOption, steps ...loadStep[string, any]) error {
 	m := make(map[string]CacheItem)
-	// Decode the file
-	if err := dec.Decode(&m); err != nil {
+	h, err := readSnapshot(r, &m)
+	if err != nil {
 		return err
 	}
-	// Clear the cache and point it to the loaded map
-	c.Clear()
-	c.items = m
-	return nil
+	if err := retypeItems(h, m); err != nil {
+		return err
+	}
+	return mergeWith(c.s, h, m, unwrapCacheItem, opts, steps...)
+}
+
+// Write every item as a line of JSON, {"key": ..., "value": ..., "expires_at": ...}, readable by jq and other tools
+// w -> Where the lines are written
+// opts -> JSONSkipExpired or JSONKeyPrefix to leave items out
+func (c *Cache) ExportJSON(w io.Writer, opts ...JSONOption) error {
+	return exportJSON(c.s, w, typeName, opts)
+}
+
+// Load lines of JSON written by ExportJSON, replacing the cache unless JSONMerge is passed
+// Nothing is changed unless every line could be read
+// r -> Where the lines are read from
+// opts -> JSONMerge to add to the cache, JSONSkipExpired or JSONKeyPrefix to leave items out
+func (c *Cache) ImportJSON(r io.Reader, opts ...JSONOption) error {
+	return importJSON(c.s, r, decodeJSONAny, opts)
 }
 
 /*
 Encryption Functions
 */
-// Get encryption objects for the cache to use
-func getEncryptionObjects() (cipher.BlockMode, cipher.BlockMode, error) {
-	if len(os.Getenv("GODISTCACHE_AES_CIPHER_KEY")) > 0 && len(os.Getenv("GODISTCACHE_AES_CIPHER_IV")) > 0 {
+// Get the cipher for the cache to use, nil if there is no key
+// CBC modes keep state between calls, so every value gets its own with a random IV instead of sharing one
+// key -> The AES key, must be 32 characters
+// iv -> The cipher IV, must be 16 characters, only checked since each value gets a random one
+func getEncryptionObjects(key, iv string) (cipher.Block, error) {
+	if len(key) > 0 && len(iv) > 0 {
 		// Enforce the length
-		if len(os.Getenv("GODISTCACHE_AES_CIPHER_KEY")) != 32 && len(os.Getenv("GODISTCACHE_AES_CIPHER_IV")) != 16 {
-			return nil, nil, errors.New("AES Key must be 32 characters and Cihper IV must be 16")
-		}
-		block, err := aes.NewCipher([]byte(os.Getenv("GODISTCACHE_AES_CIPHER_KEY")))
-		if err != nil {
-			return nil, nil, err
+		if len(key) != 32 || len(iv) != 16 {
+			return nil, ErrInvalidEncryptionKey
 		}
-		crypt := cipher.NewCBCEncrypter(block, []byte(os.Getenv("GODISTCACHE_AES_CIPHER_IV")))
-		decrypt := cipher.NewCBCDecrypter(block, []byte(os.Getenv("GODISTCACHE_AES_CIPHER_IV")))
-		return crypt, decrypt, nil
+		return aes.NewCipher([]byte(key))
 	}
-	return nil, nil, nil
+	return nil, nil
 }
 
-// Encrypt a string
+// Encrypt a string, the random IV is stored in front of the ciphertext
 func (c *Cache) encryptString(value string) string {
 	paddedValue := pkcs5Padding([]byte(value), aes.BlockSize)
-	cipherValue := make([]byte, len(paddedValue))
-	c.crypt.CryptBlocks(cipherValue, paddedValue)
+	cipherValue := make([]byte, aes.BlockSize+len(paddedValue))
+	iv := cipherValue[:aes.BlockSize]
+	// crypto/rand never fails on supported platforms
+	rand.Read(iv)
+	cipher.NewCBCEncrypter(c.block, iv).CryptBlocks(cipherValue[aes.BlockSize:], paddedValue)
 	return base64.StdEncoding.EncodeToString(cipherValue)
 }
 
@@ -362,10 +532,19 @@ func (c *Cache) encryptString(value string) string {
 func (c *Cache) decryptString(value string) (string, error) {
 	cryptVal, err := base64.StdEncoding.DecodeString(value)
 	if err != nil {
-		return "", err
+		return "", fmt.Errorf("%w: %w", ErrDecryptionFailed, err)
 	}
-	c.decrypt.CryptBlocks(cryptVal, cryptVal)
-	return string(pkcs5Unpad(cryptVal)), nil
+	// The IV and at least one block
+	if len(cryptVal) < 2*aes.BlockSize || len(cryptVal)%aes.BlockSize != 0 {
+		return "", ErrDecryptionFailed
+	}
+	iv, cryptVal := cryptVal[:aes.BlockSize], cryptVal[aes.BlockSize:]
+	cipher.NewCBCDecrypter(c.block, iv).CryptBlocks(cryptVal, cryptVal)
+	plain, ok := pkcs5Unpad(cryptVal)
+	if !ok {
+		return "", ErrDecryptionFailed
+	}
+	return string(plain), nil
 }
 
 // Pad according to PKCS#5 Standards
@@ -375,8 +554,11 @@ func pkcs5Padding(plain []byte, size int) []byte {
 	return append(plain, paddedTxt...)
 }
 
-// Unpad
-func pkcs5Unpad(v []byte) []byte {
+// Unpad, returns false if the padding is invalid
+func pkcs5Unpad(v []byte) ([]byte, bool) {
 	unpad := int(v[len(v)-1])
-	return v[:(len(v) - unpad)]
+	if unpad == 0 || unpad > len(v) {
+		return nil, false
+	}
+	return v[:(len(v) - unpad)], true
 }
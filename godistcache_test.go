@@ -5,8 +5,10 @@ import (
 	"encoding/gob"
 	"errors"
 	"fmt"
+	"io"
 	"os"
 	"strconv"
+	"sync"
 	"testing"
 	"time"
 )
@@ -50,6 +52,30 @@ func TestGoDistCacheSafePut(t *testing.T) {
 	t.Logf("Simulating %d Cache Safe PUT Requests took %s, requests per second is %f", amountOfRuns, elapsed, putsSafeTime)
 }
 
+func TestGoDistCacheSafePutUncomparable(t *testing.T) {
+	c, err := New(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !c.PutSafe("slice", []int{1, 2}) || !c.PutSafeExp("map", map[string]int{"a": 1}, 60) {
+		t.Fatalf("PutSafe didn't confirm a slice or a map")
+	}
+}
+
+func TestGoDistCacheDeleteSafe(t *testing.T) {
+	c, err := New(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	c.Put("a", 1)
+	if !c.DeleteSafe("a") || c.Exists("a") {
+		t.Fatalf("DeleteSafe didn't report the delete")
+	}
+	if c.DeleteSafe("a") || c.DeleteSafe("missing") {
+		t.Fatalf("DeleteSafe reported deleting a key that didn't exist")
+	}
+}
+
 func TestGoDistCacheGet(t *testing.T) {
 
 	c, s, objs, err := cacheCreateWithObjects()
@@ -147,7 +173,7 @@ func TestGoDistCacheSaveLoad(t *testing.T) {
 	saveTime := (1 / time.Duration.Seconds(elapsed)) * float64(amountOfRuns)
 	t.Logf("Simulating Saving %d Items took %s, items per second is %f", amountOfRuns, elapsed, saveTime)
 	// Create cache copy
-	c2, err := New(0, context.Background())
+	c2, err := New(context.Background())
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -210,14 +236,85 @@ func TestGoDistCacheCrypt(t *testing.T) {
 	t.Logf("Simulating %d Crypt Cache GET Requests took %s, requests per second is %f", amountOfRuns, elapsed, getsTime)
 }
 
-func TestGoCacheSyncToS3(t *testing.T) {
+func TestEncryptionKeyOption(t *testing.T) {
+	// Two caches in one process with their own keys
+	c1, err := New(context.Background(), WithEncryptionKey("cWlW2XekajJmuZqwAFNJTXqJ28YjiiP1", "Jh0VdNhFATWOPxvM"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	c2, err := New(context.Background(), WithEncryptionKey("KwSHE3K0jrMB6MSiQsD9DBLxZx23FHFA", "uGwDbXeAWoihBYq1"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := c1.PutCrypt("a", "secret"); err != nil {
+		t.Fatal(err)
+	}
+	if err := c2.PutCrypt("a", "secret"); err != nil {
+		t.Fatal(err)
+	}
+	v1, _ := c1.Get("a")
+	v2, _ := c2.Get("a")
+	if v1 == v2 {
+		t.Fatalf("Caches with different keys encrypted to the same value")
+	}
+	if v, err := c2.GetCrypt("a"); err != nil || v != "secret" {
+		t.Fatalf("GetCrypt returned %v, %v", v, err)
+	}
+	// Without a key encryption is off
+	c3, err := New(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := c3.PutCrypt("a", "secret"); err == nil {
+		t.Fatalf("PutCrypt worked without a key")
+	}
+}
 
-	c, s, objs, err := cacheCreateWithObjects()
+func TestEncryptionOrderAndConcurrency(t *testing.T) {
+	c, err := New(context.Background(), WithEncryptionKey("cWlW2XekajJmuZqwAFNJTXqJ28YjiiP1", "Jh0VdNhFATWOPxvM"))
 	if err != nil {
 		t.Fatal(err)
 	}
-	// Get current working directory
-	pwd, err := os.Getwd()
+	// Values decrypt no matter the order they were written and read in
+	c.PutCrypt("x", "first")
+	c.PutCrypt("y", "second")
+	c.PutCrypt("z", "first")
+	for _, key := range []string{"y", "x", "z"} {
+		v, err := c.GetCrypt(key)
+		if err != nil || (key == "y") != (v == "second") {
+			t.Fatalf("GetCrypt(%v) returned %v, %v", key, v, err)
+		}
+	}
+	// The same value doesn't encrypt to the same ciphertext twice
+	x, _ := c.Get("x")
+	z, _ := c.Get("z")
+	if x == z {
+		t.Fatalf("Two values were encrypted with the same IV")
+	}
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			key := strconv.Itoa(i)
+			for j := 0; j < 100; j++ {
+				if err := c.PutCrypt(key, key); err != nil {
+					t.Error(err)
+					return
+				}
+				if v, err := c.GetCrypt(key); err != nil || v != key {
+					t.Errorf("Concurrent GetCrypt returned %v, %v", v, err)
+					return
+				}
+			}
+		}()
+	}
+	wg.Wait()
+}
+
+func TestGoCacheSyncToS3(t *testing.T) {
+
+	c, s, objs, err := cacheCreateWithObjects()
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -225,18 +322,23 @@ func TestGoCacheSyncToS3(t *testing.T) {
 	cacheLoad(c, s, objs)
 
 	// Setup persistence
-	go c.SetupPersistToS3(5, pwd)
+	if err := c.StartPersistence(context.Background(), 5*time.Second); err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close(context.Background())
 
-	if err := c.SaveToBinaryFile(pwd + "test"); err != nil {
+	// Stream the cache straight into S3
+	pr, pw := io.Pipe()
+	go func() { pw.CloseWithError(c.WriteSnapshot(pw)) }()
+	if err := c.p.backend.Put(context.Background(), "test.godistcache", pr); err != nil {
 		t.Fatal(err)
 	}
-	c.s3.S3Upload(pwd+"test", "test")
 
 	// Wait for the S3 upload
 	time.Sleep(time.Second * 25)
 
 	// Load from S3
-	c2, err := NewFromS3(0, "test", context.Background())
+	c2, err := NewFromS3("test", context.Background(), FromEnv())
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -259,7 +361,7 @@ func cacheCreateWithObjects() (*Cache, []string, []Object, error) {
 	gob.Register(CacheItem{})
 
 	// Create Cache
-	c, err := New(0, context.Background())
+	c, err := New(context.Background(), FromEnv())
 	if err != nil {
 		return nil, nil, nil, err
 	}
@@ -273,7 +375,7 @@ func cacheCreateWithObjectsFromS3(cacheKey string) (*Cache, []string, []Object,
 	os.Setenv("GODISTCACHE_AES_CIPHER_IV", "Jh0VdNhFATWOPxvM")
 
 	// Create Cache
-	c, err := NewFromS3(0, cacheKey, context.Background())
+	c, err := NewFromS3(cacheKey, context.Background(), FromEnv())
 	if err != nil {
 		return nil, nil, nil, err
 	}
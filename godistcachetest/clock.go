@@ -0,0 +1,86 @@
+// Helpers for testing code that uses godistcache
+package godistcachetest
+
+import (
+	"sync"
+	"time"
+)
+
+// A manual clock that satisfies godistcache.Clock, time only moves when Advance or Set is called
+// Pass it to godistcache.WithClock to test expiration without sleeping
+type Clock struct {
+	mu      sync.Mutex
+	now     time.Time
+	waiters []waiter // Channels returned by After that haven't fired yet
+}
+
+// A channel returned by After, fired once the clock reaches at
+type waiter struct {
+	at time.Time
+	c  chan time.Time
+}
+
+// Creates a new manual clock
+// start -> The time the clock starts at
+func NewClock(start time.Time) *Clock {
+	return &Clock{now: start}
+}
+
+// Returns the current time of the clock
+func (c *Clock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.now
+}
+
+// Returns a channel that receives the time once the clock has moved d forward
+// d -> How long to wait, 0 or less fires right away
+func (c *Clock) After(d time.Duration) <-chan time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	ch := make(chan time.Time, 1)
+	if d <= 0 {
+		ch <- c.now
+		return ch
+	}
+	c.waiters = append(c.waiters, waiter{at: c.now.Add(d), c: ch})
+	return ch
+}
+
+// Move the clock forward and fire every After channel it passed
+// d -> How far to move the clock
+func (c *Clock) Advance(d time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.set(c.now.Add(d))
+}
+
+// Move the clock to the given time and fire every After channel it passed
+// t -> The new time of the clock
+func (c *Clock) Set(t time.Time) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.set(t)
+}
+
+// Returns the amount of After channels that haven't fired yet, useful to wait for a goroutine to start waiting
+func (c *Clock) Waiters() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return len(c.waiters)
+}
+
+// Moves the clock, the caller holds the lock
+// t -> The new time of the clock
+func (c *Clock) set(t time.Time) {
+	c.now = t
+	pending := c.waiters[:0]
+	for _, w := range c.waiters {
+		if w.at.After(t) {
+			pending = append(pending, w)
+			continue
+		}
+		w.c <- t
+	}
+	c.waiters = pending
+}
@@ -0,0 +1,38 @@
+package godistcachetest
+
+import (
+	"testing"
+	"time"
+)
+
+func TestClock(t *testing.T) {
+	start := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	c := NewClock(start)
+	after := c.After(time.Second)
+	c.Advance(500 * time.Millisecond)
+	if !c.Now().Equal(start.Add(500 * time.Millisecond)) {
+		t.Fatalf("Advance didn't move the clock: %v", c.Now())
+	}
+	select {
+	case <-after:
+		t.Fatalf("After fired early")
+	default:
+	}
+	c.Set(start.Add(time.Hour))
+	select {
+	case now := <-after:
+		if !now.Equal(start.Add(time.Hour)) {
+			t.Fatalf("After received the wrong time: %v", now)
+		}
+	default:
+		t.Fatalf("After didn't fire")
+	}
+	if c.Waiters() != 0 {
+		t.Fatalf("Fired channels are still waiting")
+	}
+	select {
+	case <-c.After(0):
+	default:
+		t.Fatalf("After(0) didn't fire right away")
+	}
+}
@@ -0,0 +1,93 @@
+package godistcache
+
+import (
+	"encoding/binary"
+	"hash/maphash"
+	"math"
+	"reflect"
+)
+
+// Hashes a key of any comparable type so keys can be spread over the shards
+// Equal keys always produce the same hash, common key types skip reflection
+// seed -> The seed of the store the key belongs to
+// key -> The key to hash
+func hashKey[K comparable](seed maphash.Seed, key K) uint64 {
+	switch k := any(key).(type) {
+	case string:
+		return maphash.String(seed, k)
+	case int:
+		return hashUint(seed, uint64(k))
+	case int64:
+		return hashUint(seed, uint64(k))
+	case int32:
+		return hashUint(seed, uint64(k))
+	case uint:
+		return hashUint(seed, uint64(k))
+	case uint64:
+		return hashUint(seed, k)
+	case uint32:
+		return hashUint(seed, uint64(k))
+	}
+	var h maphash.Hash
+	h.SetSeed(seed)
+	hashValue(&h, reflect.ValueOf(any(key)))
+	return h.Sum64()
+}
+
+// Hashes a 64 bit integer
+func hashUint(seed maphash.Seed, v uint64) uint64 {
+	var b [8]byte
+	binary.LittleEndian.PutUint64(b[:], v)
+	return maphash.Bytes(seed, b[:])
+}
+
+// Writes a comparable value to the hash by walking it with reflection
+func hashValue(h *maphash.Hash, v reflect.Value) {
+	var b [8]byte
+	switch v.Kind() {
+	case reflect.Invalid:
+		h.WriteByte(0)
+	case reflect.Bool:
+		if v.Bool() {
+			h.WriteByte(1)
+		} else {
+			h.WriteByte(0)
+		}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		binary.LittleEndian.PutUint64(b[:], uint64(v.Int()))
+		h.Write(b[:])
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		binary.LittleEndian.PutUint64(b[:], v.Uint())
+		h.Write(b[:])
+	case reflect.Float32, reflect.Float64:
+		hashFloat(h, v.Float())
+	case reflect.Complex64, reflect.Complex128:
+		hashFloat(h, real(v.Complex()))
+		hashFloat(h, imag(v.Complex()))
+	case reflect.String:
+		h.WriteString(v.String())
+	case reflect.Pointer, reflect.Chan, reflect.UnsafePointer:
+		binary.LittleEndian.PutUint64(b[:], uint64(v.Pointer()))
+		h.Write(b[:])
+	case reflect.Interface:
+		hashValue(h, v.Elem())
+	case reflect.Array:
+		for i := 0; i < v.Len(); i++ {
+			hashValue(h, v.Index(i))
+		}
+	case reflect.Struct:
+		for i := 0; i < v.NumField(); i++ {
+			hashValue(h, v.Field(i))
+		}
+	}
+}
+
+// Writes a float to the hash, -0 and +0 are equal so they hash the same
+func hashFloat(h *maphash.Hash, f float64) {
+	if f == 0 {
+		f = 0
+	}
+	var b [8]byte
+	binary.LittleEndian.PutUint64(b[:], math.Float64bits(f))
+	h.Write(b[:])
+}
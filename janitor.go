@@ -0,0 +1,91 @@
+package godistcache
+
+import (
+	"time"
+)
+
+// The amount of keys sampled from a shard in each round of a sweep
+const sweepSample = 20
+
+// Runs sweeps on the interval until the store is stopped
+// interval -> How often to sweep
+// maxKeys -> The most items checked per sweep, 0 defaults to 20 per shard
+func (s *store[K, V]) janitor(interval time.Duration, maxKeys int) {
+	if maxKeys <= 0 {
+		maxKeys = sweepSample * len(s.shards)
+	}
+	for {
+		select {
+		case <-s.clock.After(interval):
+			s.sweep(maxKeys)
+		case <-s.stop:
+			return
+		}
+	}
+}
+
+// Stop the janitor, safe to call more than once
+func (s *store[K, V]) stopJanitor() {
+	s.stopOnce.Do(func() {
+		close(s.stop)
+	})
+}
+
+// Sample keys from every shard and delete the expired ones
+// A shard is sampled again while more than a quarter of its sample was expired
+// maxKeys -> The most items checked in total, returns the amount deleted
+func (s *store[K, V]) sweep(maxKeys int) int {
+	deleted := 0
+	perShard := max(maxKeys/len(s.shards), 1)
+	for _, sh := range s.shards {
+		budget := perShard
+		for budget > 0 {
+			sampled, removed := s.sweepShard(sh, min(sweepSample, budget))
+			s.reportRemovals(removed)
+			deleted += len(removed)
+			budget -= sampled
+			if sampled == 0 || len(removed)*4 <= sampled {
+				break
+			}
+		}
+	}
+	return deleted
+}
+
+// Check up to n keys of the shard and delete the expired ones, map iteration order picks the sample
+// sh -> The shard to sample
+// n -> The amount of keys to check
+func (s *store[K, V]) sweepShard(sh *shard[K, V], n int) (sampled int, removed []removal[K, V]) {
+	sh.m.Lock()
+	defer sh.m.Unlock()
+	for k, v := range sh.items {
+		if sampled == n {
+			break
+		}
+		sampled++
+		if s.isExpired(v.e) {
+			sh.remove(k)
+			removed = append(removed, removal[K, V]{key: k, v: v.v, reason: EvictExpired})
+		}
+	}
+	return sampled, removed
+}
+
+// Check every item and delete the expired ones, returns the amount deleted
+func (s *store[K, V]) deleteExpired() int {
+	deleted := 0
+	for _, sh := range s.shards {
+		var removed []removal[K, V]
+		sh.m.Lock()
+		for k, v := range sh.items {
+			if s.isExpired(v.e) {
+				sh.remove(k)
+				removed = append(removed, removal[K, V]{key: k, v: v.v, reason: EvictExpired})
+			}
+		}
+		sh.m.Unlock()
+		s.reportRemovals(removed)
+		deleted += len(removed)
+	}
+	return deleted
+}
@@ -0,0 +1,85 @@
+package godistcache
+
+import (
+	"context"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/mbarreca/godistcache/godistcachetest"
+)
+
+func TestDeleteExpired(t *testing.T) {
+	c, err := New(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < amountOfRuns; i++ {
+		if i%2 == 0 {
+			c.PutExp(strconv.Itoa(i), i, -1)
+		} else {
+			c.Put(strconv.Itoa(i), i)
+		}
+	}
+	if deleted := c.DeleteExpired(); deleted != amountOfRuns/2 {
+		t.Fatalf("Deleted %v expired items, should be %v", deleted, amountOfRuns/2)
+	}
+	if c.Count() != amountOfRuns/2 {
+		t.Fatalf("Amount of Cache Items != Amount Counted")
+	}
+}
+
+func TestJanitor(t *testing.T) {
+	c := newTestTyped[int, int](t, WithJanitor(10*time.Millisecond, 0))
+	defer c.Stop()
+	for i := 0; i < amountOfRuns; i++ {
+		c.PutExp(i, i, -1)
+	}
+	c.Put(amountOfRuns, amountOfRuns)
+	// Expired items are never read, the janitor has to find them
+	deadline := time.Now().Add(5 * time.Second)
+	for c.Count() > 1 {
+		if time.Now().After(deadline) {
+			t.Fatalf("Janitor didn't delete expired items, %v left", c.Count())
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if !c.Exists(amountOfRuns) {
+		t.Fatalf("Janitor deleted an item that wasn't expired")
+	}
+	// Stopping twice is safe
+	c.Stop()
+}
+
+func TestSweepBudget(t *testing.T) {
+	c := newTestTyped[int, int](t, WithShards(1))
+	for i := 0; i < amountOfRuns; i++ {
+		c.PutExp(i, i, -1)
+	}
+	if deleted := c.s.sweep(100); deleted != 100 {
+		t.Fatalf("Sweep deleted %v items, should be limited to 100", deleted)
+	}
+}
+
+func TestJanitorClock(t *testing.T) {
+	clock := godistcachetest.NewClock(time.Now())
+	c := newTestTyped[int, int](t, WithJanitor(time.Minute, 0), WithClock(clock))
+	defer c.Stop()
+	c.PutTTL(1, 1, 30*time.Second)
+	c.PutTTL(2, 2, 2*time.Minute)
+	// Wait for the janitor to start waiting on the clock, then run one sweep
+	for clock.Waiters() == 0 {
+		time.Sleep(time.Millisecond)
+	}
+	clock.Advance(time.Minute)
+	deadline := time.Now().Add(5 * time.Second)
+	for c.Count() > 1 {
+		if time.Now().After(deadline) {
+			t.Fatalf("Janitor didn't delete the expired item")
+		}
+		time.Sleep(time.Millisecond)
+	}
+	if !c.Exists(2) {
+		t.Fatalf("Janitor deleted an item that wasn't expired")
+	}
+}
@@ -0,0 +1,136 @@
+package godistcache
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// Loads the value of a key on a cache miss
+// Returns the value, how long to keep it (DefaultExpiration, NoExpiration or a TTL) and an error if it couldn't be loaded
+type Loader[V any] func(ctx context.Context) (V, time.Duration, error)
+
+// Reloads the value of any key in the background, for stale-while-revalidate and refresh-ahead
+// Returns the value, how long to keep it (DefaultExpiration, NoExpiration or a TTL) and an error if it couldn't be loaded
+type Refresher[K comparable, V any] func(ctx context.Context, key K) (V, time.Duration, error)
+
+// Collapses concurrent loads of the same key into one call
+type flightGroup[K comparable, V any] struct {
+	m     sync.Mutex
+	calls map[K]*flight[V] // The loads in progress
+}
+
+// A load in progress, done is closed once v and err are set
+type flight[V any] struct {
+	done chan struct{}
+	v    V
+	err  error
+}
+
+// Returns the load in progress for the key, or registers a new one
+// Returns true if the caller registered the load and has to run it and call finish
+// key -> The key being loaded
+func (g *flightGroup[K, V]) start(key K) (*flight[V], bool) {
+	g.m.Lock()
+	defer g.m.Unlock()
+	if f, ok := g.calls[key]; ok {
+		return f, false
+	}
+	if g.calls == nil {
+		g.calls = make(map[K]*flight[V])
+	}
+	// Waiting callers get this if the load panics
+	f := &flight[V]{done: make(chan struct{}), err: ErrLoaderPanicked}
+	g.calls[key] = f
+	return f, true
+}
+
+// Unregisters the load and wakes up the callers waiting on it
+// key -> The key being loaded
+// f -> The load returned by start
+func (g *flightGroup[K, V]) finish(key K, f *flight[V]) {
+	g.m.Lock()
+	delete(g.calls, key)
+	g.m.Unlock()
+	close(f.done)
+}
+
+// Get an item from the store, or load it once no matter how many callers miss at the same time
+// key -> The key to lookup in the store
+// ctx -> Passed to the loader, waiting callers stop waiting when their own context is done
+// loader -> Loads the value on a miss, also refreshes stale items when no Refresher is registered
+func (s *store[K, V]) getOrLoad(ctx context.Context, key K, loader Loader[V]) (V, error) {
+	r := s.callbacks().refresh
+	if r == nil {
+		r = func(ctx context.Context, key K) (V, time.Duration, error) { return loader(ctx) }
+	}
+	if v, ok, _ := s.lookupWith(key, r); ok {
+		return v, nil
+	}
+	// Errors are cached for a shorter time so a failing backend isn't hammered
+	if s.negative != nil {
+		if err, ok := s.negative.get(key); ok {
+			var zero V
+			return zero, err
+		}
+	}
+	f, owner := s.flights.start(key)
+	if !owner {
+		select {
+		case <-f.done:
+			return f.v, f.err
+		case <-ctx.Done():
+			var zero V
+			return zero, ctx.Err()
+		}
+	}
+	defer s.flights.finish(key, f)
+	// Another load may have finished since the first check
+	if v, ok := s.get(key); ok {
+		f.v, f.err = v, nil
+		return v, nil
+	}
+	v, ttl, err := loader(ctx)
+	f.v, f.err = v, err
+	if err != nil {
+		if s.negative != nil {
+			s.negative.put(key, err, DefaultExpiration)
+		}
+		return v, err
+	}
+	s.put(key, v, ttl)
+	return v, nil
+}
+
+// Tells you whether or not a read should refresh the item, because it's stale or near the end of its lifetime
+// v -> The item that was read
+// now -> The time of the read in Unix UTC nanoseconds
+func (s *store[K, V]) needsRefresh(v entry[V], now int64) bool {
+	if v.soft < now {
+		return true
+	}
+	if s.ahead <= 0 || v.soft <= v.born {
+		return false
+	}
+	threshold := v.soft - int64(float64(v.soft-v.born)*s.ahead)
+	return now >= threshold
+}
+
+// Reload an item in the background, unless it's already being loaded
+// Errors are ignored, the current value keeps being served until it expires
+// key -> The key to reload
+// r -> Reloads the item
+func (s *store[K, V]) refresh(key K, r Refresher[K, V]) {
+	f, owner := s.flights.start(key)
+	if !owner {
+		return
+	}
+	go func() {
+		defer s.flights.finish(key, f)
+		v, ttl, err := r(context.Background(), key)
+		f.v, f.err = v, err
+		if err == nil {
+			s.put(key, v, ttl)
+		}
+	}()
+}
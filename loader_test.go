@@ -0,0 +1,91 @@
+package godistcache
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestGetOrLoad(t *testing.T) {
+	c, err := New(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	var calls atomic.Int32
+	release := make(chan struct{})
+	loader := func(ctx context.Context) (any, time.Duration, error) {
+		calls.Add(1)
+		<-release
+		return "loaded", time.Minute, nil
+	}
+	// Every concurrent miss shares the one loader call
+	var wg sync.WaitGroup
+	for i := 0; i < 50; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			v, err := c.GetOrLoad(context.Background(), "a", loader)
+			if err != nil || v != "loaded" {
+				t.Errorf("GetOrLoad returned %v, %v", v, err)
+			}
+		}()
+	}
+	time.Sleep(50 * time.Millisecond)
+	close(release)
+	wg.Wait()
+	if calls.Load() != 1 {
+		t.Fatalf("Loader was called %v times", calls.Load())
+	}
+	if v, e := c.Get("a"); !e || v != "loaded" {
+		t.Fatalf("Loaded value wasn't cached")
+	}
+	// Hits don't call the loader
+	c.GetOrLoad(context.Background(), "a", loader)
+	if calls.Load() != 1 {
+		t.Fatalf("Loader was called on a hit")
+	}
+}
+
+func TestGetOrLoadNegative(t *testing.T) {
+	errMissing := errors.New("missing")
+	var calls atomic.Int32
+	loader := func(ctx context.Context) (int, time.Duration, error) {
+		calls.Add(1)
+		return 0, 0, errMissing
+	}
+	c := newTestTyped[string, int](t, WithNegativeCache(time.Minute))
+	for i := 0; i < 3; i++ {
+		if _, err := c.GetOrLoad(context.Background(), "a", loader); err != errMissing {
+			t.Fatalf("Error wasn't returned: %v", err)
+		}
+	}
+	if calls.Load() != 1 || c.Exists("a") {
+		t.Fatalf("Error wasn't cached, loader was called %v times", calls.Load())
+	}
+	// Without negative caching every miss calls the loader
+	c2 := newTestTyped[string, int](t)
+	c2.GetOrLoad(context.Background(), "a", loader)
+	c2.GetOrLoad(context.Background(), "a", loader)
+	if calls.Load() != 3 {
+		t.Fatalf("Loader was called %v times", calls.Load())
+	}
+}
+
+func TestGetOrLoadCancel(t *testing.T) {
+	c := newTestTyped[string, int](t)
+	release := make(chan struct{})
+	defer close(release)
+	go c.GetOrLoad(context.Background(), "a", func(ctx context.Context) (int, time.Duration, error) {
+		<-release
+		return 1, 0, nil
+	})
+	time.Sleep(50 * time.Millisecond)
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if _, err := c.GetOrLoad(ctx, "a", nil); err != context.DeadlineExceeded {
+		t.Fatalf("Waiting caller didn't stop with its context: %v", err)
+	}
+}
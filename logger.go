@@ -0,0 +1,16 @@
+package godistcache
+
+import "log/slog"
+
+// Receives what the cache logs, *slog.Logger satisfies it
+type Logger interface {
+	Debug(msg string, args ...any)
+	Info(msg string, args ...any)
+	Warn(msg string, args ...any)
+	Error(msg string, args ...any)
+}
+
+// The logger used without WithLogger
+func defaultLogger() Logger {
+	return slog.Default()
+}
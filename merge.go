@@ -0,0 +1,161 @@
+package godistcache
+
+import (
+	"fmt"
+	"reflect"
+	"time"
+)
+
+// Decides which item a key keeps when it's both in the cache and in what is merged into it
+type MergeStrategy uint8
+
+const (
+	MergeKeepExisting MergeStrategy = iota // The cache keeps what it has, the default
+	MergeOverwrite                         // The merged item replaces what the cache has
+	MergeNewestExpiry                      // The item that expires last wins, one that never expires beats both, the cache keeps what it has on a tie
+)
+
+// Changes how MergeFromBinary and MergeFromBackend combine a file with the cache
+type MergeOption func(*mergeOptions)
+
+// The settings a MergeOption can change
+type mergeOptions struct {
+	strategy    MergeStrategy // Picks the winner of a conflict
+	resolve     any           // A func(K, MergeItem[V], MergeItem[V]) MergeItem[V] used instead of the strategy, nil to use it
+	skipExpired bool          // Leave out items whose expiration has passed
+}
+
+// An item on either side of a conflict while merging
+type MergeItem[V any] struct {
+	Value     V             // The value
+	ExpiresAt time.Time     // When it expires, the zero time never expires
+	Sliding   time.Duration // How far each read moves the expiration, 0 is a fixed expiration
+}
+
+// Pick how conflicts are settled, defaults to MergeKeepExisting
+// strategy -> MergeKeepExisting, MergeOverwrite or MergeNewestExpiry
+func MergeWith(strategy MergeStrategy) MergeOption {
+	return func(o *mergeOptions) {
+		o.strategy = strategy
+		o.resolve = nil
+	}
+}
+
+// Settle conflicts with your own function, which replaces the strategy
+// It runs while the key is locked, so it must not call back into the cache
+// f -> Receives the key, the item the cache has and the one being merged, returns the item to keep, K and V have to match the cache
+func MergeResolver[K comparable, V any](f func(key K, existing, merged MergeItem[V]) MergeItem[V]) MergeOption {
+	return func(o *mergeOptions) {
+		o.resolve = f
+	}
+}
+
+// Leave out items whose expiration has already passed instead of merging them
+func MergeSkipExpired() MergeOption {
+	return func(o *mergeOptions) {
+		o.skipExpired = true
+	}
+}
+
+// Returns the function settling conflicts for a store of K and V, which returns false to keep the existing item
+// Errors if a resolver was given for other types
+func resolver[K comparable, V any](o mergeOptions) (func(key K, existing, merged importedItem[V]) (importedItem[V], bool), error) {
+	if o.resolve != nil {
+		f, ok := o.resolve.(func(K, MergeItem[V], MergeItem[V]) MergeItem[V])
+		if !ok {
+			// %T of a zero V prints <nil> for interfaces like any
+			return nil, fmt.Errorf("godistcache: the merge resolver has to take a %v key and %v values", reflect.TypeFor[K](), reflect.TypeFor[V]())
+		}
+		return func(key K, existing, merged importedItem[V]) (importedItem[V], bool) {
+			return fromMergeItem(f(key, existing.mergeItem(), merged.mergeItem())), true
+		}, nil
+	}
+	switch o.strategy {
+	case MergeKeepExisting:
+		return func(key K, existing, merged importedItem[V]) (importedItem[V], bool) {
+			return existing, false
+		}, nil
+	case MergeOverwrite:
+		return func(key K, existing, merged importedItem[V]) (importedItem[V], bool) {
+			return merged, true
+		}, nil
+	case MergeNewestExpiry:
+		return func(key K, existing, merged importedItem[V]) (importedItem[V], bool) {
+			return merged, merged.soft > existing.soft
+		}, nil
+	}
+	return nil, fmt.Errorf("godistcache: unknown merge strategy %v", o.strategy)
+}
+
+// Converts an item into what a resolver receives
+func (i importedItem[V]) mergeItem() MergeItem[V] {
+	m := MergeItem[V]{Value: i.v, Sliding: i.slide}
+	if i.soft != noExpiry {
+		m.ExpiresAt = time.Unix(0, i.soft)
+	}
+	return m
+}
+
+// Converts what a resolver returns into an item
+func fromMergeItem[V any](m MergeItem[V]) importedItem[V] {
+	i := importedItem[V]{v: m.Value, soft: noExpiry, slide: m.Sliding}
+	if !m.ExpiresAt.IsZero() {
+		i.soft = m.ExpiresAt.UnixNano()
+	}
+	return i
+}
+
+// Run the steps over a decoded snapshot, then merge the result into the store
+// s -> The store to merge into
+// h -> The header of the snapshot
+// m -> The decoded snapshot
+// unwrap -> Converts an item of the snapshot into its value, expiration and sliding expiration
+// opts -> How conflicts are settled and which items are left out
+// steps -> Run in order, nil steps are skipped
+func mergeWith[K comparable, V any, I any](s *store[K, V], h SnapshotHeader, m map[K]I, unwrap func(I) (V, int64, time.Duration), opts []MergeOption, steps ...loadStep[K, V]) error {
+	var o mergeOptions
+	for _, opt := range opts {
+		opt(&o)
+	}
+	resolve, err := resolver[K, V](o)
+	if err != nil {
+		return err
+	}
+	items, err := applySteps(h, m, unwrap, steps)
+	if err != nil {
+		return err
+	}
+	for k, i := range items {
+		if o.skipExpired && s.isExpired(i.soft) {
+			continue
+		}
+		s.mergeAt(k, i, resolve)
+	}
+	return nil
+}
+
+// Store a merged item, letting resolve settle it with the item the store already has under the key
+// The decision is made under the shard lock, so a write landing at the same time is never lost
+// key -> The key to lookup in the store
+// merged -> The item being merged
+// resolve -> Returns the item to store and whether or not to store it
+func (s *store[K, V]) mergeAt(key K, merged importedItem[V], resolve func(key K, existing, merged importedItem[V]) (importedItem[V], bool)) {
+	sh := s.shardFor(key)
+	sh.m.Lock()
+	// Expired items don't count as a conflict
+	if old, ok := sh.items[key]; ok && !s.isExpired(old.e) {
+		var store bool
+		if merged, store = resolve(key, importedItem[V]{v: old.v, soft: old.soft, slide: old.slide}, merged); !store {
+			sh.m.Unlock()
+			return
+		}
+	}
+	var size int64
+	if s.sizer != nil {
+		size = s.sizer(key, merged.v)
+	}
+	removed, stored := s.setLocked(sh, key, merged.v, merged.soft, merged.slide, size)
+	sh.m.Unlock()
+	s.reportRemovals(removed)
+	s.reportInsertions(stored)
+}
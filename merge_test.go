@@ -0,0 +1,111 @@
+package godistcache
+
+import (
+	"context"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/mbarreca/godistcache/godistcachetest"
+	"github.com/mbarreca/godistcache/storage"
+)
+
+func TestMergeFromBinary(t *testing.T) {
+	clock := godistcachetest.NewClock(time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC))
+	path := filepath.Join(t.TempDir(), "cache")
+	file := newTestTyped[string, int](t, WithClock(clock))
+	file.PutTTL("a", 1, time.Hour)
+	file.PutTTL("b", 2, 2*time.Hour)
+	file.PutTTL("c", 3, -time.Second)
+	if err := file.SaveToBinaryFile(path); err != nil {
+		t.Fatal(err)
+	}
+	// The cache has written since it started
+	cache := func() *TypedCache[string, int] {
+		c := newTestTyped[string, int](t, WithClock(clock))
+		c.PutTTL("a", 10, 3*time.Hour)
+		c.PutTTL("b", 20, time.Minute)
+		c.Put("d", 40)
+		return c
+	}
+	tests := []struct {
+		name string
+		opts []MergeOption
+		a, b int
+	}{
+		{"keep existing", nil, 10, 20},
+		{"overwrite", []MergeOption{MergeWith(MergeOverwrite)}, 1, 2},
+		{"newest expiry", []MergeOption{MergeWith(MergeNewestExpiry)}, 10, 2},
+		{"resolver", []MergeOption{MergeResolver(func(key string, existing, merged MergeItem[int]) MergeItem[int] {
+			existing.Value += merged.Value
+			return existing
+		})}, 11, 22},
+	}
+	for _, test := range tests {
+		c := cache()
+		if err := c.MergeFromBinary(path, test.opts...); err != nil {
+			t.Fatalf("%v: %v", test.name, err)
+		}
+		a, _ := c.Get("a")
+		b, _ := c.Get("b")
+		if a != test.a || b != test.b || !c.Exists("d") {
+			t.Fatalf("%v: merged a=%v b=%v, should be a=%v b=%v", test.name, a, b, test.a, test.b)
+		}
+		// Without MergeSkipExpired the expired item is still merged
+		if c.Count() != 4 {
+			t.Fatalf("%v: expected 4 items, got %v", test.name, c.Count())
+		}
+	}
+	c := cache()
+	if err := c.MergeFromBinary(path, MergeSkipExpired()); err != nil {
+		t.Fatal(err)
+	}
+	if c.Count() != 3 {
+		t.Fatalf("Expired item was merged: %v items", c.Count())
+	}
+	if ttl, _ := c.TTL("a"); ttl != 3*time.Hour {
+		t.Fatalf("Keeping the existing item changed its expiration: %v", ttl)
+	}
+	// A resolver for other types is refused
+	wrong := MergeResolver(func(key int, existing, merged MergeItem[int]) MergeItem[int] { return merged })
+	if err := c.MergeFromBinary(path, wrong); err == nil {
+		t.Fatalf("Resolver for the wrong key type was accepted")
+	}
+	// The error names the types Cache needs, which are interfaces
+	var o mergeOptions
+	wrong(&o)
+	if _, err := resolver[string, any](o); err == nil || !strings.Contains(err.Error(), "string key and interface {} values") {
+		t.Fatalf("Resolver error doesn't name the types: %v", err)
+	}
+}
+
+func TestMergeFromBackend(t *testing.T) {
+	ctx := context.Background()
+	backend := storage.NewMemory()
+	opts := []Option{WithBackend(backend), WithObjectKey("test"), WithDeltaSnapshots(10)}
+	c, err := New(ctx, opts...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c.Put("a", "a")
+	if err := c.p.persist(ctx, c.WriteSnapshot); err != nil {
+		t.Fatal(err)
+	}
+	c.Put("b", "b")
+	if err := c.p.persist(ctx, c.WriteSnapshot); err != nil {
+		t.Fatal(err)
+	}
+	c2, err := New(ctx, WithBackend(backend))
+	if err != nil {
+		t.Fatal(err)
+	}
+	c2.Put("a", "mine")
+	c2.Put("own", "own")
+	if err := c2.MergeFromBackend(ctx, "test"); err != nil {
+		t.Fatal(err)
+	}
+	if v, _ := c2.Get("a"); v != "mine" || !c2.Exists("b") || !c2.Exists("own") {
+		t.Fatalf("Merge lost items: a=%v, %v items", v, c2.Count())
+	}
+}
@@ -0,0 +1,348 @@
+package godistcache
+
+import (
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/mbarreca/godistcache/storage"
+)
+
+// Configures a cache when passed to New, NewFromS3, NewTyped or NewTypedFromS3
+type Option func(*options)
+
+// The settings an Option can change
+type options struct {
+	defaultTTL  time.Duration   // How long items live unless told otherwise, DefaultExpiration never expires
+	s3          *storage.Config // How to connect to S3, nil without S3
+	backend     storage.Backend // Where the cache is saved and loaded, instead of connecting with s3
+	instanceID  string          // Names this instance's daily backups
+	objectKey   string          // The key StartPersistence uploads to
+	cipherKey   string          // The AES key, encryption is off without it
+	cipherIV    string          // The AES IV
+	err         error           // The first error an option ran into, returned by the constructors
+	logger      Logger          // Receives what the cache logs
+	onError     func(error)     // Called with every background persistence failure
+	compression Compression     // How snapshots are compressed
+	codec       Codec           // How the items of snapshots are encoded
+	fileMode    os.FileMode     // The permissions of files written by SaveToBinaryFile
+	generations int             // How many previous versions of its file SaveToBinaryFile keeps
+
+	walDir         string  // Where the write-ahead log is kept, "" disables it
+	walSync        WALSync // How often the write-ahead log is flushed to disk
+	walSegmentSize int64   // The size each segment of the write-ahead log grows to, 0 uses the default
+
+	fullEvery int // Every how many persistence runs a full snapshot is uploaded, deltas in between, 0 always uploads full snapshots
+
+	maxEntries int                         // Maximum amount of items, 0 is unlimited
+	newPolicy  func() EvictionPolicy       // Creates the eviction policy used once a limit is reached
+	maxBytes   int64                       // Maximum estimated size of all items, 0 is unlimited
+	sizer      Sizer                       // Estimates the size of an item
+	onEvict    func(key any, total uint64) // Called after each capacity eviction
+	shards     int                         // Amount of segments the items are spread over, 0 picks automatically
+
+	sweepInterval time.Duration // How often the janitor deletes expired items, 0 disables it
+	sweepKeys     int           // The most items the janitor checks per sweep
+
+	negativeTTL time.Duration // How long GetOrLoad caches loader errors, 0 disables it
+
+	grace time.Duration // How long stale items are served while they are refreshed
+	ahead float64       // Fraction of the lifetime at the end of which reads refresh an item
+
+	sliding bool // Every read extends the expiration of the item by its TTL
+
+	clock Clock // Tells the time for expiration, the janitor and persistence
+}
+
+// Applies the options on top of the defaults
+func newOptions(opts []Option) options {
+	o := options{newPolicy: NewLRU, clock: RealClock, logger: defaultLogger(), objectKey: "cache", codec: Gob, fileMode: 0o644}
+	for _, opt := range opts {
+		opt(&o)
+	}
+	return o
+}
+
+// Bound the cache to a maximum amount of items, once reached Put evicts according to the eviction policy
+// max -> The maximum amount of items, 0 is unlimited
+func WithMaxEntries(max int) Option {
+	return func(o *options) {
+		o.maxEntries = max
+	}
+}
+
+// Bound the cache to a memory budget, once the estimated size of all items exceeds it Put evicts according to the eviction policy
+// max -> The budget in bytes, 0 is unlimited
+func WithMaxBytes(max int64) Option {
+	return func(o *options) {
+		o.maxBytes = max
+	}
+}
+
+// Choose how the size of an item is estimated, defaults to ReflectSizer
+// Setting a Sizer also tracks Bytes() when there is no memory budget
+// sizer -> Estimates the size of an item in bytes
+func WithSizer(sizer Sizer) Option {
+	return func(o *options) {
+		o.sizer = sizer
+	}
+}
+
+// Choose how items are evicted once the maximum amount of items or bytes is reached, defaults to NewLRU
+// newPolicy -> Creates the policy, use NewLRU, NewLFU, NewFIFO or your own
+func WithEvictionPolicy(newPolicy func() EvictionPolicy) Option {
+	return func(o *options) {
+		o.newPolicy = newPolicy
+	}
+}
+
+// Get notified every time an item is evicted because the cache is full
+// f -> Receives the evicted key and the total amount of evictions so far
+func WithEvictionCallback(f func(key any, total uint64)) Option {
+	return func(o *options) {
+		o.onEvict = f
+	}
+}
+
+// Choose how many segments the items are spread over, each with its own lock
+// Defaults to 4 per GOMAXPROCS, fewer for small limits. Limits and eviction apply per shard, so WithShards(1) gives exact LRU/LFU/FIFO order
+// n -> The amount of shards, rounded up to a power of two
+func WithShards(n int) Option {
+	return func(o *options) {
+		o.shards = n
+	}
+}
+
+// Start a background janitor that deletes expired items, without it expired items are only deleted when read
+// Each sweep samples keys from every shard and keeps going while more than a quarter of them were expired, like Redis does
+// Call Stop to shut it down
+// interval -> How often to sweep
+// maxKeys -> The most items checked per sweep, 0 defaults to 20 per shard
+func WithJanitor(interval time.Duration, maxKeys int) Option {
+	return func(o *options) {
+		o.sweepInterval = interval
+		o.sweepKeys = maxKeys
+	}
+}
+
+// Cache the errors returned by GetOrLoad loaders, so a missing or failing key doesn't hit your backend on every read
+// ttl -> How long to keep an error, usually shorter than the default expiration
+func WithNegativeCache(ttl time.Duration) Option {
+	return func(o *options) {
+		o.negativeTTL = ttl
+	}
+}
+
+// Keep serving items for a grace period after they expire while they are refreshed in the background
+// Refreshing uses the Refresher registered with SetRefresher, or the loader passed to GetOrLoad
+// Without either a stale item is a miss, it's deleted once the grace period is over
+// grace -> How long after its expiration an item can still be served
+func WithStaleWhileRevalidate(grace time.Duration) Option {
+	return func(o *options) {
+		o.grace = grace
+	}
+}
+
+// Refresh hot items in the background before they expire, so reads never see them go stale
+// A read in the last part of an item's lifetime triggers a refresh through the Refresher or GetOrLoad loader
+// fraction -> The part of the lifetime, e.g. 0.1 refreshes items read in the last 10% of their lifetime
+func WithRefreshAhead(fraction float64) Option {
+	return func(o *options) {
+		o.ahead = fraction
+	}
+}
+
+// Make every item use a sliding expiration, each read moves its expiration its TTL from now
+// Use PutSliding instead to only make some items slide
+func WithSlidingExpiration() Option {
+	return func(o *options) {
+		o.sliding = true
+	}
+}
+
+// Choose the clock used for expiration, the janitor, the persistence loop and the names of S3 backups, defaults to RealClock
+// Use the manual clock from godistcachetest to test expiration without sleeping
+// clock -> The clock to use
+func WithClock(clock Clock) Option {
+	return func(o *options) {
+		o.clock = clock
+	}
+}
+
+// Set how long items live when they're stored without their own expiration, defaults to never expiring
+// ttl -> The default time to live, NoExpiration never expires
+func WithDefaultTTL(ttl time.Duration) Option {
+	return func(o *options) {
+		o.defaultTTL = ttl
+	}
+}
+
+// Save to and load from an S3 compatible store, a shortcut for WithBackend
+// cfg -> How to connect
+func WithS3(cfg storage.Config) Option {
+	return func(o *options) {
+		o.s3 = &cfg
+	}
+}
+
+// Choose where NewFromBackend loads from and StartPersistence saves to
+// storage has S3, FS for the local filesystem and Memory for tests, or use your own
+// backend -> Where the cache files are stored
+func WithBackend(backend storage.Backend) Option {
+	return func(o *options) {
+		o.backend = backend
+	}
+}
+
+// Name this instance's daily backups so instances sharing a backend don't overwrite each other
+// id -> The ID of this instance
+func WithInstanceID(id string) Option {
+	return func(o *options) {
+		o.instanceID = id
+	}
+}
+
+// Set the key StartPersistence uploads to, defaults to "cache"
+// key -> The key in the backend - DO NOT include the .godistcache extension
+func WithObjectKey(key string) Option {
+	return func(o *options) {
+		o.objectKey = key
+	}
+}
+
+// Turn on AES encryption for PutCrypt and GetCrypt
+// key -> The AES key, must be 32 characters
+// iv -> Must be 16 characters, each value is encrypted with its own random IV stored next to it
+func WithEncryptionKey(key, iv string) Option {
+	return func(o *options) {
+		o.cipherKey = key
+		o.cipherIV = iv
+	}
+}
+
+// Compress snapshots written by SaveToBinaryFile, WriteSnapshot and persistence, defaults to CompressionNone
+// Loading reads the compression from the file, so caches can load snapshots compressed either way
+// c -> CompressionNone, CompressionGzip, CompressionZstd or CompressionS2
+func WithCompression(c Compression) Option {
+	return func(o *options) {
+		if c > CompressionS2 {
+			o.err = fmt.Errorf("godistcache: unknown compression %v", c)
+			return
+		}
+		o.compression = c
+	}
+}
+
+// Choose how the items of snapshots are encoded, defaults to Gob
+// Loading reads the codec from the file, so caches can load snapshots encoded with any registered codec
+// With any codec but Gob, Cache values need their types registered with RegisterType to load back as the same type
+// c -> Gob, JSON, MessagePack, CBOR or a codec registered with RegisterCodec
+func WithCodec(c Codec) Option {
+	return func(o *options) {
+		if _, ok := lookupCodec(c.ID()); !ok {
+			o.err = fmt.Errorf("godistcache: codec %v isn't registered, call RegisterCodec first", c.Name())
+			return
+		}
+		o.codec = c
+	}
+}
+
+// Choose the permissions of files written by SaveToBinaryFile, defaults to 0644
+// mode -> The permissions, 0600 keeps the file private to its owner
+func WithFileMode(mode os.FileMode) Option {
+	return func(o *options) {
+		o.fileMode = mode.Perm()
+	}
+}
+
+// Keep previous versions of the file when SaveToBinaryFile replaces it, as path.1.godistcache for the last one, path.2.godistcache for the one before and so on
+// Each version loads with LoadFromBinary like any other file
+// n -> How many previous versions to keep, 0 keeps none
+func WithFileGenerations(n int) Option {
+	return func(o *options) {
+		if n < 0 {
+			o.err = fmt.Errorf("godistcache: can't keep %v generations", n)
+			return
+		}
+		o.generations = n
+	}
+}
+
+// Record every write in an append-only log in dir, so a crash only loses what the sync policy allows instead of everything since the last snapshot
+// LoadFromBinary and NewFromBackend replay the log on top of the snapshot they load, and saving a snapshot deletes the part of the log it holds
+// dir -> The directory the log is kept in, one per cache
+func WithWAL(dir string) Option {
+	return func(o *options) {
+		o.walDir = dir
+	}
+}
+
+// Choose how often the write-ahead log is flushed to disk, defaults to WALSyncEverySecond
+// policy -> WALSyncAlways, WALSyncEverySecond or WALSyncNever
+func WithWALSync(policy WALSync) Option {
+	return func(o *options) {
+		o.walSync = policy
+	}
+}
+
+// Choose the size each segment of the write-ahead log grows to before the next one starts, defaults to 64 MiB
+// size -> The size in bytes
+func WithWALSegmentSize(size int64) Option {
+	return func(o *options) {
+		o.walSegmentSize = size
+	}
+}
+
+// Make persistence upload only the keys changed since the previous run, as small delta objects between full snapshots
+// NewFromBackend applies the deltas of the snapshot it loads in order, a Clear or a load always leads to a full snapshot
+// fullEvery -> Every how many runs a full snapshot is uploaded, 1 uploads one every time
+func WithDeltaSnapshots(fullEvery int) Option {
+	return func(o *options) {
+		if fullEvery < 1 {
+			o.err = fmt.Errorf("godistcache: a full snapshot is needed every 1 or more runs, got %v", fullEvery)
+			return
+		}
+		o.fullEvery = fullEvery
+	}
+}
+
+// Configure the cache from the GODISTCACHE_* environment variables, the way it was before options existed
+// Options passed after FromEnv override what it sets
+func FromEnv() Option {
+	return func(o *options) {
+		if key, iv := os.Getenv("GODISTCACHE_AES_CIPHER_KEY"), os.Getenv("GODISTCACHE_AES_CIPHER_IV"); len(key) > 0 && len(iv) > 0 {
+			o.cipherKey = key
+			o.cipherIV = iv
+		}
+		if os.Getenv("GODISTCACHE_S3_ENDPOINT") != "" {
+			cfg, err := storage.ConfigFromEnv()
+			if err != nil {
+				o.err = err
+				return
+			}
+			o.s3 = &cfg
+		}
+		if id := os.Getenv("GODISTCACHE_INSTANCE_ID"); id != "" {
+			o.instanceID = id
+		}
+		if key := os.Getenv("GODISTCACHE_S3_OBJECT"); key != "" {
+			o.objectKey = key
+		}
+	}
+}
+
+// Choose where the cache logs to, defaults to slog.Default()
+// logger -> Receives the logs, a *slog.Logger works
+func WithLogger(logger Logger) Option {
+	return func(o *options) {
+		o.logger = logger
+	}
+}
+
+// Get notified when background persistence to S3 fails, the failure is logged either way
+// f -> Receives the error
+func WithErrorHandler(f func(err error)) Option {
+	return func(o *options) {
+		o.onError = f
+	}
+}
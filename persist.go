@@ -0,0 +1,362 @@
+package godistcache
+
+import (
+	"bufio"
+	"cmp"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/mbarreca/godistcache/storage"
+)
+
+// The extension used for every cache file
+const fileExtension = ".godistcache"
+
+// Saves a cache to a .godistcache file with the provided function
+// The cache is written to a temporary file next to it, synced to disk and renamed over the old file, so a crash leaves either the old or the new file
+// filePathName -> The path with the filename - DO NOT add the extension .godistcache
+// mode -> The permissions of the file
+// generations -> How many previous versions to keep as filePathName.1.godistcache, filePathName.2.godistcache and so on
+// save -> The function that encodes the cache
+func writeBinaryFile(filePathName string, mode os.FileMode, generations int, save func(io.Writer) error) (err error) {
+	path := filePathName + fileExtension
+	dir := filepath.Dir(path)
+	file, err := os.CreateTemp(dir, "."+filepath.Base(path)+".tmp*")
+	if err != nil {
+		return err
+	}
+	// Leave nothing behind if anything fails
+	defer func() {
+		if err != nil {
+			file.Close()
+			os.Remove(file.Name())
+		}
+	}()
+	w := bufio.NewWriter(file)
+	if err := save(w); err != nil {
+		return err
+	}
+	if err := w.Flush(); err != nil {
+		return err
+	}
+	if err := file.Chmod(mode); err != nil {
+		return err
+	}
+	if err := file.Sync(); err != nil {
+		return err
+	}
+	if err := file.Close(); err != nil {
+		return err
+	}
+	if err := rotateGenerations(filePathName, generations); err != nil {
+		return err
+	}
+	if err := os.Rename(file.Name(), path); err != nil {
+		return err
+	}
+	return syncDir(dir)
+}
+
+// Returns the path of a previous version of a .godistcache file
+// filePathName -> The path with the filename, without the extension
+// n -> How many versions ago, starting at 1
+func generationPath(filePathName string, n int) string {
+	return filePathName + "." + strconv.Itoa(n) + fileExtension
+}
+
+// Shift the previous versions of a file up by one and keep the current one as the first, dropping the oldest
+// The current file stays where it is until the new one is renamed over it
+// filePathName -> The path with the filename, without the extension
+// generations -> How many previous versions to keep, 0 keeps none
+func rotateGenerations(filePathName string, generations int) error {
+	if generations <= 0 {
+		return nil
+	}
+	path := filePathName + fileExtension
+	if _, err := os.Stat(path); errors.Is(err, fs.ErrNotExist) {
+		return nil
+	}
+	if err := os.Remove(generationPath(filePathName, generations)); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return err
+	}
+	for n := generations - 1; n >= 1; n-- {
+		if err := os.Rename(generationPath(filePathName, n), generationPath(filePathName, n+1)); err != nil && !errors.Is(err, fs.ErrNotExist) {
+			return err
+		}
+	}
+	// Filesystems without hard links lose the file for a moment instead
+	if err := os.Link(path, generationPath(filePathName, 1)); err != nil {
+		return os.Rename(path, generationPath(filePathName, 1))
+	}
+	return nil
+}
+
+// Flush a rename in dir to disk
+// dir -> The directory holding the renamed file
+func syncDir(dir string) error {
+	// Windows can't open a directory to sync it
+	if runtime.GOOS == "windows" {
+		return nil
+	}
+	d, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	defer d.Close()
+	return d.Sync()
+}
+
+// Opens a .godistcache file and loads it with the provided function
+// filePathName -> The path with the filename - DO NOT add the extension .godistcache
+// load -> The function that decodes the file into a cache
+func loadBinaryFile(filePathName string, load func(io.Reader) error) error {
+	// Open the file
+	file, err := os.Open(filePathName + fileExtension)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	return load(bufio.NewReader(file))
+}
+
+// Saves a cache to a backend in the background and reports what goes wrong
+type persister struct {
+	backend    storage.Backend // Where the cache is saved, nil without a backend
+	object     string          // The key uploads go to, without the extension
+	instanceID string          // Names this instance's daily backups
+	clock      Clock           // Times the interval and names the daily backups
+	log        checkpointer    // The write-ahead log each upload compacts, nil without one
+	deltas     *deltaChain     // Uploads changes between full snapshots, nil to always upload full snapshots
+	logger     Logger          // Receives background failures
+	onError    func(error)     // Also receives background failures, nil to only log them
+
+	mu     sync.Mutex         // Held while the cache is saved and uploaded, so runs never overlap
+	lm     sync.Mutex         // Protects cancel and done
+	cancel context.CancelFunc // Stops the loop, nil when it was never started
+	done   chan struct{}      // Closed once the loop has exited
+}
+
+// Creates the persister of a cache, connecting to S3 if it's set up
+// ctx -> The context you want to provide for purposes of telemetry
+// o -> The options the cache was created with
+func newPersister(ctx context.Context, o options) (*persister, error) {
+	p := &persister{backend: o.backend, object: o.objectKey, instanceID: o.instanceID, clock: o.clock, logger: o.logger, onError: o.onError}
+	if o.fullEvery > 0 {
+		p.deltas = &deltaChain{fullEvery: o.fullEvery}
+	}
+	if p.backend == nil && o.s3 != nil {
+		s3, err := storage.NewWithConfig(ctx, *o.s3)
+		if err != nil {
+			return nil, fmt.Errorf("%w: %w", ErrBackendUnavailable, err)
+		}
+		p.backend = s3
+	}
+	return p, nil
+}
+
+// The error returned when there is no backend
+var errNoBackend = fmt.Errorf("%w: no backend, use WithBackend, WithS3 or FromEnv", ErrBackendUnavailable)
+
+// Downloads a cache file from the backend and loads it with the provided function
+// ctx -> The context you want to provide for purposes of telemetry
+// cacheKey -> The key of the file in the backend - DO NOT include the .godistcache extension
+// load -> The function that decodes the file into a cache
+func (p *persister) load(ctx context.Context, cacheKey string, load func(io.Reader) error) error {
+	if p.backend == nil {
+		return errNoBackend
+	}
+	r, err := p.backend.Get(ctx, cacheKey+fileExtension)
+	if errors.Is(err, storage.ErrNotExist) {
+		return fmt.Errorf("%w: %w", ErrNotFound, err)
+	}
+	if err != nil {
+		return fmt.Errorf("%w: %w", ErrBackendUnavailable, err)
+	}
+	defer r.Close()
+	return load(bufio.NewReader(r))
+}
+
+// Start saving the cache to the backend every interval in the background
+// ctx -> The loop stops once it's done
+// interval -> How long to wait between the end of one run and the start of the next
+// save -> The function that encodes the cache
+func (p *persister) start(ctx context.Context, interval time.Duration, save func(io.Writer) error) error {
+	if p.backend == nil {
+		return errNoBackend
+	}
+	p.lm.Lock()
+	defer p.lm.Unlock()
+	if p.done != nil {
+		select {
+		case <-p.done:
+		default:
+			return ErrPersistenceStarted
+		}
+	}
+	ctx, p.cancel = context.WithCancel(ctx)
+	p.done = make(chan struct{})
+	go p.loop(ctx, interval, save, p.done)
+	return nil
+}
+
+// Saves the cache every interval until the context is done, failures are logged and passed to the error handler
+// ctx -> Stops the loop once it's done
+// interval -> How long to wait between runs
+// save -> The function that encodes the cache
+// done -> Closed when the loop exits
+func (p *persister) loop(ctx context.Context, interval time.Duration, save func(io.Writer) error, done chan struct{}) {
+	defer close(done)
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-p.clock.After(interval):
+		}
+		if err := p.persist(ctx, save); err != nil {
+			p.report(err)
+		}
+	}
+}
+
+// Stop the loop and wait for it to exit, a run in progress is canceled with it
+func (p *persister) stop() {
+	p.lm.Lock()
+	cancel, done := p.cancel, p.done
+	p.cancel, p.done = nil, nil
+	p.lm.Unlock()
+	if cancel == nil {
+		return
+	}
+	cancel()
+	<-done
+}
+
+// Stop the loop, then save the cache to the backend one last time if there is one
+// Nothing reads the cache for persistence once this returns, even when ctx ends the final save early
+// ctx -> Cancels the final save, which is aborted instead of left running
+// save -> The function that encodes the cache
+func (p *persister) close(ctx context.Context, save func(io.Writer) error) error {
+	p.stop()
+	if p.backend == nil {
+		return nil
+	}
+	return p.persist(ctx, save)
+}
+
+// Stream the cache to the backend as today's backup for this instance and as the latest version
+// ctx -> Cancels the upload
+// save -> The function that encodes the cache
+func (p *persister) persist(ctx context.Context, save func(io.Writer) error) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return checkpoint(p.log, func() error {
+		return p.persistLocked(ctx, save)
+	})
+}
+
+// Upload the cache to the backend, must hold mu
+// ctx -> Cancels the upload
+// save -> The function that encodes the cache
+func (p *persister) persistLocked(ctx context.Context, save func(io.Writer) error) error {
+	if p.deltas != nil {
+		return p.persistDelta(ctx, save)
+	}
+	return p.persistFull(ctx, save)
+}
+
+// Upload the whole cache as today's backup for this instance and as the latest version, must hold mu
+// ctx -> Cancels the upload
+// save -> The function that encodes the cache
+func (p *persister) persistFull(ctx context.Context, save func(io.Writer) error) error {
+	backup := p.object + "_" + p.instanceID + "_" + p.clock.Now().Format("01-02-2006") + fileExtension
+	latest := p.object + fileExtension
+	// Backends that can copy only need the cache once
+	if c, ok := p.backend.(storage.Copier); ok {
+		if err := p.upload(ctx, save, backup); err != nil {
+			return err
+		}
+		if err := c.Copy(ctx, backup, latest); err != nil {
+			return fmt.Errorf("%w: %w", ErrBackendUnavailable, err)
+		}
+		return nil
+	}
+	return p.upload(ctx, save, backup, latest)
+}
+
+// Encode the cache once and stream it to every key at the same time, nothing touches the disk
+// ctx -> Cancels the upload
+// save -> The function that encodes the cache
+// keys -> The keys to upload to
+func (p *persister) upload(ctx context.Context, save func(io.Writer) error, keys ...string) error {
+	pipes := make([]*io.PipeWriter, len(keys))
+	writers := make([]io.Writer, len(keys))
+	var wg sync.WaitGroup
+	// One failure aborts every leg, which then fail with it too, only the first one is the cause
+	var first struct {
+		sync.Mutex
+		err error
+	}
+	fail := func(err error) {
+		first.Lock()
+		if first.err == nil {
+			first.err = err
+		}
+		first.Unlock()
+	}
+	for i, key := range keys {
+		pr, pw := io.Pipe()
+		pipes[i], writers[i] = pw, pw
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			err := p.backend.Put(ctx, key, pr)
+			if err != nil {
+				err = fmt.Errorf("%w: %w", ErrBackendUnavailable, err)
+				// Before the encoder can see it through the pipe
+				fail(err)
+			}
+			// Stop the encoder if the upload gave up before reading everything
+			pr.CloseWithError(cmp.Or(err, io.ErrClosedPipe))
+		}()
+	}
+	// Stop the encoder as soon as ctx is done, even if a backend ignores it and keeps reading
+	defer context.AfterFunc(ctx, func() {
+		// Writes to a closed pipe fail with io.ErrClosedPipe, which isn't the cause
+		fail(ctx.Err())
+		for _, pw := range pipes {
+			pw.CloseWithError(ctx.Err())
+		}
+	})()
+	w := bufio.NewWriter(io.MultiWriter(writers...))
+	err := save(w)
+	if err == nil {
+		err = w.Flush()
+	}
+	if err != nil {
+		fail(err)
+	}
+	// A nil error ends the uploads, anything else aborts them
+	for _, pw := range pipes {
+		pw.CloseWithError(err)
+	}
+	wg.Wait()
+	return first.err
+}
+
+// Log a background failure and pass it to the error handler
+// err -> What went wrong
+func (p *persister) report(err error) {
+	p.logger.Error("godistcache: persisting to the backend failed", "err", err)
+	if p.onError != nil {
+		p.onError(err)
+	}
+}
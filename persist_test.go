@@ -0,0 +1,203 @@
+package godistcache
+
+import (
+	"context"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/mbarreca/godistcache/godistcachetest"
+	"github.com/mbarreca/godistcache/storage"
+)
+
+// A backend whose uploads always fail
+type failingBackend struct {
+	*storage.Memory
+}
+
+func (failingBackend) Put(ctx context.Context, key string, r io.Reader) error {
+	return errors.New("backend down")
+}
+
+func TestStartPersistence(t *testing.T) {
+	clock := godistcachetest.NewClock(time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC))
+	backend := storage.NewMemory()
+	opts := []Option{WithBackend(backend), WithClock(clock), WithObjectKey("test"), WithInstanceID("i1")}
+	c := newTestTyped[string, int](t, opts...)
+	c.Put("a", 1)
+	ctx, cancel := context.WithCancel(context.Background())
+	if err := c.StartPersistence(ctx, time.Minute); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.StartPersistence(ctx, time.Minute); !errors.Is(err, ErrPersistenceStarted) {
+		t.Fatalf("Second StartPersistence returned %v", err)
+	}
+	// Nothing runs before the interval passes
+	waitFor(t, "the loop to wait", func() bool { return clock.Waiters() == 1 })
+	if keys, _ := backend.List(context.Background(), ""); len(keys) != 0 {
+		t.Fatalf("Persistence ran before the interval: %v", keys)
+	}
+	clock.Advance(time.Minute)
+	// The next run is only scheduled once the previous one is done
+	waitFor(t, "the next run to wait", func() bool { return clock.Waiters() == 1 })
+	keys, _ := backend.List(context.Background(), "")
+	if want := []string{"test.godistcache", "test_i1_03-01-2024.godistcache"}; !reflect.DeepEqual(keys, want) {
+		t.Fatalf("Persistence uploaded %v, should be %v", keys, want)
+	}
+	// Cancelling the context stops the loop, so it can be started again
+	cancel()
+	waitFor(t, "the loop to stop", func() bool { return c.StartPersistence(context.Background(), time.Minute) == nil })
+	// Close stops the loop and flushes one last time
+	c.Put("b", 2)
+	if err := c.Close(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	c2, err := NewTypedFromBackend[string, int]("test", context.Background(), opts...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if v, ok := c2.Get("b"); !ok || v != 2 {
+		t.Fatalf("Close didn't flush the cache")
+	}
+	if _, err := NewTypedFromBackend[string, int]("missing", context.Background(), opts...); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("Loading a missing key returned %v", err)
+	}
+}
+
+func TestPersistenceFailures(t *testing.T) {
+	clock := godistcachetest.NewClock(time.Now())
+	var failures atomic.Int32
+	c, err := New(context.Background(), WithBackend(failingBackend{storage.NewMemory()}), WithClock(clock), WithErrorHandler(func(err error) {
+		failures.Add(1)
+	}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := c.StartPersistence(context.Background(), time.Minute); err != nil {
+		t.Fatal(err)
+	}
+	waitFor(t, "the loop to wait", func() bool { return clock.Waiters() == 1 })
+	clock.Advance(time.Minute)
+	waitFor(t, "the failure to be reported", func() bool { return failures.Load() == 1 })
+	if err := c.Close(context.Background()); !errors.Is(err, ErrBackendUnavailable) {
+		t.Fatalf("Close didn't return the final upload's error: %v", err)
+	}
+}
+
+func TestCloseWithoutBackend(t *testing.T) {
+	c := newTestTyped[string, int](t, WithJanitor(time.Millisecond, 0))
+	if err := c.Close(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	// The cache is still usable after Close
+	c.Put("a", 1)
+	if v, ok := c.Get("a"); !ok || v != 1 {
+		t.Fatalf("Cache didn't work after Close")
+	}
+}
+
+func TestPersistLargeCacheFailure(t *testing.T) {
+	// The upload gives up before reading anything, the encoder must not block on it
+	c := newTestTyped[int, string](t, WithBackend(failingBackend{storage.NewMemory()}))
+	for i := 0; i < 100000; i++ {
+		c.Put(i, "value")
+	}
+	err := c.Close(context.Background())
+	if !errors.Is(err, ErrBackendUnavailable) {
+		t.Fatalf("Close returned %v", err)
+	}
+	// Every upload failed because of the first one, it's only reported once
+	if n := strings.Count(err.Error(), "backend down"); n != 1 {
+		t.Fatalf("The failure was reported %v times: %v", n, err)
+	}
+}
+
+// A backend whose uploads hang until they're canceled
+type hangingBackend struct {
+	*storage.Memory
+	started  chan struct{}
+	once     sync.Once
+	returned atomic.Int32
+}
+
+func (b *hangingBackend) Put(ctx context.Context, key string, r io.Reader) error {
+	b.once.Do(func() { close(b.started) })
+	<-ctx.Done()
+	b.returned.Add(1)
+	return ctx.Err()
+}
+
+func TestCloseCanceled(t *testing.T) {
+	backend := &hangingBackend{Memory: storage.NewMemory(), started: make(chan struct{})}
+	c := newTestTyped[string, int](t, WithBackend(backend))
+	c.Put("a", 1)
+	ctx, cancel := context.WithCancel(context.Background())
+	go func() {
+		<-backend.started
+		cancel()
+	}()
+	if err := c.Close(ctx); !errors.Is(err, context.Canceled) {
+		t.Fatalf("Close returned %v", err)
+	}
+	// The final save was aborted, not left running
+	if backend.returned.Load() != 2 {
+		t.Fatalf("Close returned while the upload was still running")
+	}
+}
+
+func TestSaveToBinaryFileGenerations(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "cache")
+	c, err := New(context.Background(), WithFileMode(0o600), WithFileGenerations(2))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 1; i <= 4; i++ {
+		c.Put("version", i)
+		if err := c.SaveToBinaryFile(path); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// The latest file and the two before it
+	for name, want := range map[string]int{"cache": 4, "cache.1": 3, "cache.2": 2} {
+		c2, err := New(context.Background())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := c2.LoadFromBinary(filepath.Join(dir, name)); err != nil {
+			t.Fatal(err)
+		}
+		if v, _ := c2.Get("version"); v != want {
+			t.Fatalf("%v holds version %v, should be %v", name, v, want)
+		}
+	}
+	info, err := os.Stat(path + fileExtension)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Mode().Perm() != 0o600 {
+		t.Fatalf("File was written with %v", info.Mode().Perm())
+	}
+	// A save that fails leaves the files alone and no temporary file behind
+	c.Put("broken", make(chan int))
+	if err := c.SaveToBinaryFile(path); err == nil {
+		t.Fatalf("Saving a value Gob can't encode didn't fail")
+	}
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 3 {
+		t.Fatalf("Expected 3 files, found %v", len(entries))
+	}
+	if _, err := New(context.Background(), WithFileGenerations(-1)); err == nil {
+		t.Fatalf("Negative generations were accepted")
+	}
+}
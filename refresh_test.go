@@ -0,0 +1,119 @@
+package godistcache
+
+import (
+	"context"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// Wait until the condition is true or fail after a few seconds
+func waitFor(t *testing.T, what string, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatalf("Timed out waiting for %s", what)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+func TestStaleWhileRevalidate(t *testing.T) {
+	c, err := New(context.Background(), WithStaleWhileRevalidate(time.Minute))
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Stale items are misses until there is something to refresh them with
+	c.PutExp("a", "old", -1)
+	if _, e := c.Get("a"); e {
+		t.Fatalf("Stale item was served without a refresher")
+	}
+	var calls atomic.Int32
+	c.SetRefresher(func(ctx context.Context, key string) (any, time.Duration, error) {
+		calls.Add(1)
+		return "new", time.Minute, nil
+	})
+	if v, e := c.Get("a"); !e || v != "old" {
+		t.Fatalf("Stale item wasn't served: %v", v)
+	}
+	waitFor(t, "the refresh", func() bool {
+		v, _ := c.Get("a")
+		return v == "new"
+	})
+	if calls.Load() != 1 {
+		t.Fatalf("Refresher was called %v times", calls.Load())
+	}
+	// Past the grace period the item is gone
+	c.PutExp("b", "old", -61)
+	if _, e := c.Get("b"); e || c.Exists("b") {
+		t.Fatalf("Item was served after its grace period")
+	}
+}
+
+func TestStaleWhileRevalidateGetOrLoad(t *testing.T) {
+	c := newTestTyped[string, string](t, WithStaleWhileRevalidate(time.Minute))
+	c.PutExp("a", "old", -1)
+	loader := func(ctx context.Context) (string, time.Duration, error) {
+		return "new", time.Minute, nil
+	}
+	// The loader refreshes the stale item in the background
+	if v, err := c.GetOrLoad(context.Background(), "a", loader); err != nil || v != "old" {
+		t.Fatalf("Stale item wasn't served: %v, %v", v, err)
+	}
+	waitFor(t, "the refresh", func() bool {
+		v, _ := c.Get("a")
+		return v == "new"
+	})
+}
+
+func TestRefreshAhead(t *testing.T) {
+	c := newTestTyped[string, int](t, WithRefreshAhead(1))
+	var calls atomic.Int32
+	c.SetRefresher(func(ctx context.Context, key string) (int, time.Duration, error) {
+		return int(calls.Add(1)), time.Hour, nil
+	})
+	c.PutExp("a", 0, 3600)
+	// Every read is within the whole lifetime, so the first read refreshes it
+	if v, e := c.Get("a"); !e || v != 0 {
+		t.Fatalf("Item wasn't served while refreshing: %v", v)
+	}
+	waitFor(t, "the refresh", func() bool {
+		v, _ := c.Get("a")
+		return v > 0
+	})
+
+	// Without refresh-ahead fresh items are never refreshed
+	c2 := newTestTyped[string, int](t)
+	c2.SetRefresher(func(ctx context.Context, key string) (int, time.Duration, error) {
+		t.Errorf("Fresh item was refreshed")
+		return 0, 0, nil
+	})
+	c2.PutExp("a", 0, 3600)
+	c2.Get("a")
+	time.Sleep(20 * time.Millisecond)
+}
+
+// A clock that counts how often it's read
+type countingClock struct {
+	Clock
+	reads atomic.Int64
+}
+
+func (c *countingClock) Now() time.Time {
+	c.reads.Add(1)
+	return c.Clock.Now()
+}
+
+func TestGetReadsClockOnce(t *testing.T) {
+	clock := &countingClock{Clock: RealClock}
+	c := newTestTyped[string, int](t, WithClock(clock), WithStaleWhileRevalidate(time.Minute))
+	c.PutTTL("a", 1, time.Hour)
+	clock.reads.Store(0)
+	if _, ok := c.Get("a"); !ok {
+		t.Fatalf("Item is missing")
+	}
+	if n := clock.reads.Load(); n != 1 {
+		t.Fatalf("Get read the clock %v times", n)
+	}
+}
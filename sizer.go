@@ -0,0 +1,105 @@
+package godistcache
+
+import (
+	"reflect"
+)
+
+// Estimates how many bytes an item takes up in memory, used with WithMaxBytes
+// key -> The key of the item
+// value -> The value of the item
+type Sizer func(key, value any) int64
+
+// The default Sizer, walks the key and value with reflection and adds up their memory
+// Shared pointers are only counted once per item, unsafe.Pointer and channels only count their header
+func ReflectSizer(key, value any) int64 {
+	seen := make(map[uintptr]struct{})
+	return valueSize(reflect.ValueOf(key), seen) + valueSize(reflect.ValueOf(value), seen)
+}
+
+// Estimate the memory held by v, including everything it points to
+func valueSize(v reflect.Value, seen map[uintptr]struct{}) int64 {
+	if !v.IsValid() {
+		return 0
+	}
+	return int64(v.Type().Size()) + indirectSize(v, seen)
+}
+
+// Estimate the memory v points to, outside of its own inline size
+func indirectSize(v reflect.Value, seen map[uintptr]struct{}) int64 {
+	switch v.Kind() {
+	case reflect.String:
+		return int64(v.Len())
+	case reflect.Slice:
+		if v.IsNil() || visited(v.Pointer(), seen) {
+			return 0
+		}
+		n := int64(v.Cap()) * int64(v.Type().Elem().Size())
+		if hasIndirect(v.Type().Elem()) {
+			for i := 0; i < v.Len(); i++ {
+				n += indirectSize(v.Index(i), seen)
+			}
+		}
+		return n
+	case reflect.Array:
+		var n int64
+		if hasIndirect(v.Type().Elem()) {
+			for i := 0; i < v.Len(); i++ {
+				n += indirectSize(v.Index(i), seen)
+			}
+		}
+		return n
+	case reflect.Map:
+		if v.IsNil() || visited(v.Pointer(), seen) {
+			return 0
+		}
+		var n int64
+		iter := v.MapRange()
+		for iter.Next() {
+			n += valueSize(iter.Key(), seen) + valueSize(iter.Value(), seen)
+		}
+		return n
+	case reflect.Pointer:
+		if v.IsNil() || visited(v.Pointer(), seen) {
+			return 0
+		}
+		return valueSize(v.Elem(), seen)
+	case reflect.Interface:
+		if v.IsNil() {
+			return 0
+		}
+		return valueSize(v.Elem(), seen)
+	case reflect.Struct:
+		var n int64
+		for i := 0; i < v.NumField(); i++ {
+			n += indirectSize(v.Field(i), seen)
+		}
+		return n
+	}
+	return 0
+}
+
+// Records the address and tells you whether or not it was already counted
+func visited(p uintptr, seen map[uintptr]struct{}) bool {
+	if _, ok := seen[p]; ok {
+		return true
+	}
+	seen[p] = struct{}{}
+	return false
+}
+
+// Tells you whether or not values of the type can point to more memory
+func hasIndirect(t reflect.Type) bool {
+	switch t.Kind() {
+	case reflect.String, reflect.Slice, reflect.Map, reflect.Pointer, reflect.Interface:
+		return true
+	case reflect.Array:
+		return hasIndirect(t.Elem())
+	case reflect.Struct:
+		for i := 0; i < t.NumField(); i++ {
+			if hasIndirect(t.Field(i).Type) {
+				return true
+			}
+		}
+	}
+	return false
+}
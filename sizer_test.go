@@ -0,0 +1,74 @@
+package godistcache
+
+import (
+	"context"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func TestReflectSizer(t *testing.T) {
+	small := ReflectSizer("a", Object{One: "One", Two: 2, Three: 3.3})
+	large := ReflectSizer("a", Object{One: strings.Repeat("a", 1<<20), Two: 2, Three: 3.3})
+	if small <= 0 || large-small != 1<<20-3 {
+		t.Fatalf("Unexpected sizes, small: %v, large: %v", small, large)
+	}
+	// Shared and cyclic pointers are only counted once
+	type node struct {
+		Next *node
+		Data []byte
+	}
+	n := &node{Data: make([]byte, 1024)}
+	n.Next = n
+	if s := ReflectSizer("a", n); s < 1024 || s > 2048 {
+		t.Fatalf("Unexpected size for cyclic value: %v", s)
+	}
+}
+
+func TestMaxBytes(t *testing.T) {
+	c, err := New(context.Background(), WithMaxBytes(64*1024))
+	if err != nil {
+		t.Fatal(err)
+	}
+	value := strings.Repeat("a", 1024)
+	for i := 0; i < amountOfRuns; i++ {
+		c.Put(strconv.Itoa(i), value)
+		if c.Bytes() > 64*1024 {
+			t.Fatalf("Cache grew past its budget: %v", c.Bytes())
+		}
+	}
+	if c.Evictions() == 0 || !c.Exists(strconv.Itoa(amountOfRuns-1)) || c.Exists("0") {
+		t.Fatalf("Budget didn't evict the oldest items")
+	}
+	// Growing an item evicts others, but not the item itself
+	c.Put(strconv.Itoa(amountOfRuns-1), strings.Repeat("a", 32*1024))
+	if c.Bytes() > 64*1024 || !c.Exists(strconv.Itoa(amountOfRuns-1)) {
+		t.Fatalf("Growing an item broke the budget: %v", c.Bytes())
+	}
+	// Deleting and clearing release the bytes
+	for i := 0; i < amountOfRuns; i++ {
+		c.Delete(strconv.Itoa(i))
+	}
+	if c.Bytes() != 0 {
+		t.Fatalf("Deleting didn't release the bytes: %v", c.Bytes())
+	}
+}
+
+func TestSizer(t *testing.T) {
+	c := newTestTyped[string, string](t, WithMaxBytes(10), WithSizer(func(key, value any) int64 {
+		return int64(len(value.(string)))
+	}))
+	c.Put("a", "12345")
+	c.Put("b", "12345")
+	if c.Bytes() != 10 || c.Count() != 2 {
+		t.Fatalf("Bytes: %v, Count: %v", c.Bytes(), c.Count())
+	}
+	c.Put("c", "1")
+	if c.Bytes() != 6 || c.Exists("a") {
+		t.Fatalf("Bytes: %v, Count: %v", c.Bytes(), c.Count())
+	}
+	c.Clear()
+	if c.Bytes() != 0 {
+		t.Fatalf("Clear didn't release the bytes: %v", c.Bytes())
+	}
+}
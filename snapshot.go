@@ -0,0 +1,330 @@
+package godistcache
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"encoding/gob"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"os"
+	"time"
+)
+
+// A .godistcache file starts with the magic bytes, then the header and its checksum
+// The encoded cache follows in blocks, each one its length, its bytes and their checksum
+// An empty block ends the cache, followed by the total length and checksum of every block
+// Files written before the header existed are a bare gob encoding of the cache and are still loaded
+
+// Starts every versioned file, 0x89 can't start a gob stream so it never matches an older file
+var snapshotMagic = []byte("\x89GDC\r\n\x1a\n")
+
+// The format version written by this package
+const snapshotVersion = 1
+
+// The most bytes buffered before a block is written
+const snapshotBlockSize = 64 << 10
+
+// Checksums are CRC32C
+var castagnoli = crc32.MakeTable(crc32.Castagnoli)
+
+// Describes a snapshot, read from the start of the file
+type SnapshotHeader struct {
+	Version     uint8       // The format version, 0 for files written before the header existed
+	Created     time.Time   // When the snapshot was taken
+	InstanceID  string      // The instance that took it
+	Entries     uint64      // How many items it holds
+	Codec       CodecID     // How the items are encoded
+	Compression Compression // How it's compressed
+}
+
+// How a cache writes its snapshots
+type snapshotConfig struct {
+	clock       Clock       // Timestamps the header
+	instanceID  string      // Recorded in the header
+	compression Compression // How the items are compressed
+	codec       Codec       // How the items are encoded
+	fileMode    os.FileMode // The permissions of files written by SaveToBinaryFile
+	generations int         // How many previous versions SaveToBinaryFile keeps
+}
+
+// Creates the snapshot config of a cache
+// o -> The options the cache was created with
+func newSnapshotConfig(o options) snapshotConfig {
+	return snapshotConfig{clock: o.clock, instanceID: o.instanceID, compression: o.compression, codec: o.codec, fileMode: o.fileMode, generations: o.generations}
+}
+
+// Returns the header of a snapshot taken now
+// entries -> How many items it holds
+func (sc snapshotConfig) header(entries int) SnapshotHeader {
+	return SnapshotHeader{Created: sc.clock.Now(), InstanceID: sc.instanceID, Entries: uint64(entries), Codec: sc.codec.ID(), Compression: sc.compression}
+}
+
+// Read the header of a snapshot without loading it, older files return a header with Version 0 and nothing else set
+// r -> Where the snapshot is read from
+func ReadSnapshotHeader(r io.Reader) (SnapshotHeader, error) {
+	h, _, err := readSnapshotHeader(bufio.NewReader(r))
+	return h, err
+}
+
+// Encode data as a versioned snapshot with per-block checksums
+// w -> Where the snapshot is written
+// h -> The header, Version is set here, Codec and Compression pick how the items are encoded
+// data -> The map of items to encode
+func writeSnapshot(w io.Writer, h SnapshotHeader, data any) error {
+	h.Version = snapshotVersion
+	c, ok := lookupCodec(h.Codec)
+	if !ok {
+		return fmt.Errorf("godistcache: codec %v isn't registered", h.Codec)
+	}
+	if err := writeSnapshotHeader(w, h); err != nil {
+		return err
+	}
+	// Blocks hold the compressed bytes, so damage is caught before anything is decompressed
+	bw := &blockWriter{w: w, buf: make([]byte, 0, snapshotBlockSize)}
+	cw, err := compressWriter(h.Compression, bw)
+	if err != nil {
+		return err
+	}
+	if err := c.NewEncoder(cw).Encode(data); err != nil {
+		cw.Close()
+		return err
+	}
+	if err := cw.Close(); err != nil {
+		return err
+	}
+	return bw.Close()
+}
+
+// Decode a snapshot into data, checking every checksum before returning
+// Older headerless files are decoded as they are, they have nothing to check
+// r -> Where the snapshot is read from
+// data -> A pointer to the map of items to decode into
+func readSnapshot(r io.Reader, data any) (SnapshotHeader, error) {
+	br := bufio.NewReader(r)
+	h, legacy, err := readSnapshotHeader(br)
+	if err != nil {
+		return h, err
+	}
+	if legacy {
+		h.Codec = CodecGob
+		return h, gob.NewDecoder(br).Decode(data)
+	}
+	c, ok := lookupCodec(h.Codec)
+	if !ok {
+		return h, fmt.Errorf("%w: unsupported codec %v, register it with RegisterCodec", ErrCorruptSnapshot, h.Codec)
+	}
+	blocks := &blockReader{r: br}
+	cr, err := decompressReader(h.Compression, blocks)
+	if err != nil {
+		return h, blocks.corrupt(err)
+	}
+	defer cr.Close()
+	if err := c.NewDecoder(cr).Decode(data); err != nil {
+		return h, blocks.corrupt(err)
+	}
+	// The decoder can stop before the end, the compressed stream and the trailer still have to be checked
+	if _, err := io.Copy(io.Discard, cr); err != nil {
+		return h, blocks.corrupt(err)
+	}
+	if _, err := io.Copy(io.Discard, blocks); err != nil {
+		return h, err
+	}
+	return h, nil
+}
+
+// Write the magic bytes and the header followed by its checksum
+// w -> Where the snapshot is written
+// h -> The header to write
+func writeSnapshotHeader(w io.Writer, h SnapshotHeader) error {
+	if len(h.InstanceID) > 0xFFFF {
+		return fmt.Errorf("godistcache: instance ID is longer than %v bytes", 0xFFFF)
+	}
+	var buf bytes.Buffer
+	buf.Write(snapshotMagic)
+	buf.Write([]byte{h.Version, byte(h.Codec), byte(h.Compression)})
+	buf.Write(binary.BigEndian.AppendUint64(nil, uint64(h.Created.UnixNano())))
+	buf.Write(binary.BigEndian.AppendUint64(nil, h.Entries))
+	buf.Write(binary.BigEndian.AppendUint16(nil, uint16(len(h.InstanceID))))
+	buf.WriteString(h.InstanceID)
+	buf.Write(binary.BigEndian.AppendUint32(nil, crc32.Checksum(buf.Bytes(), castagnoli)))
+	_, err := w.Write(buf.Bytes())
+	return err
+}
+
+// Read and check the header, returns true without consuming anything if the file predates it
+// r -> Where the snapshot is read from
+func readSnapshotHeader(r *bufio.Reader) (SnapshotHeader, bool, error) {
+	magic, err := r.Peek(len(snapshotMagic))
+	if !bytes.Equal(magic, snapshotMagic) {
+		if len(magic) > 0 && magic[0] == snapshotMagic[0] {
+			return SnapshotHeader{}, false, fmt.Errorf("%w: bad magic bytes", ErrCorruptSnapshot)
+		}
+		// Nothing at all is an empty file, not an old one
+		if len(magic) == 0 {
+			return SnapshotHeader{}, false, fmt.Errorf("%w: %w", ErrCorruptSnapshot, err)
+		}
+		return SnapshotHeader{}, true, nil
+	}
+	// Magic, version, codec, compression, created, entries and the instance ID length
+	fixed := make([]byte, len(snapshotMagic)+3+8+8+2)
+	if _, err := io.ReadFull(r, fixed); err != nil {
+		return SnapshotHeader{}, false, truncated(err)
+	}
+	f := fixed[len(snapshotMagic):]
+	h := SnapshotHeader{
+		Version:     f[0],
+		Codec:       CodecID(f[1]),
+		Compression: Compression(f[2]),
+		Created:     time.Unix(0, int64(binary.BigEndian.Uint64(f[3:11]))),
+		Entries:     binary.BigEndian.Uint64(f[11:19]),
+	}
+	if h.Version != snapshotVersion {
+		return h, false, fmt.Errorf("%w: unsupported version %v", ErrCorruptSnapshot, h.Version)
+	}
+	rest := make([]byte, int(binary.BigEndian.Uint16(f[19:21]))+4)
+	if _, err := io.ReadFull(r, rest); err != nil {
+		return h, false, truncated(err)
+	}
+	id := rest[:len(rest)-4]
+	sum := crc32.Update(crc32.Checksum(fixed, castagnoli), castagnoli, id)
+	if sum != binary.BigEndian.Uint32(rest[len(rest)-4:]) {
+		return h, false, fmt.Errorf("%w: header checksum mismatch", ErrCorruptSnapshot)
+	}
+	h.InstanceID = string(id)
+	return h, false, nil
+}
+
+// Turns running out of bytes into a corrupt snapshot error
+// err -> The read error
+func truncated(err error) error {
+	if errors.Is(err, io.EOF) {
+		err = io.ErrUnexpectedEOF
+	}
+	return fmt.Errorf("%w: %w", ErrCorruptSnapshot, err)
+}
+
+// Splits what's written to it into checksummed blocks
+type blockWriter struct {
+	w   io.Writer
+	buf []byte // The block being filled
+	sum uint32 // The checksum of every block written so far
+	n   uint64 // The length of every block written so far
+}
+
+// Buffer p, writing every block that fills up
+func (b *blockWriter) Write(p []byte) (int, error) {
+	written := len(p)
+	for len(p) > 0 {
+		n := copy(b.buf[len(b.buf):cap(b.buf)], p)
+		b.buf, p = b.buf[:len(b.buf)+n], p[n:]
+		if len(b.buf) == cap(b.buf) {
+			if err := b.flush(); err != nil {
+				return 0, err
+			}
+		}
+	}
+	return written, nil
+}
+
+// Write the buffered bytes as a block
+func (b *blockWriter) flush() error {
+	if len(b.buf) == 0 {
+		return nil
+	}
+	frame := binary.BigEndian.AppendUint32(nil, uint32(len(b.buf)))
+	frame = append(frame, b.buf...)
+	frame = binary.BigEndian.AppendUint32(frame, crc32.Checksum(b.buf, castagnoli))
+	if _, err := b.w.Write(frame); err != nil {
+		return err
+	}
+	b.sum = crc32.Update(b.sum, castagnoli, b.buf)
+	b.n += uint64(len(b.buf))
+	b.buf = b.buf[:0]
+	return nil
+}
+
+// Write the last block, the empty block that ends the cache and the trailer
+func (b *blockWriter) Close() error {
+	if err := b.flush(); err != nil {
+		return err
+	}
+	trailer := binary.BigEndian.AppendUint32(nil, 0)
+	trailer = binary.BigEndian.AppendUint64(trailer, b.n)
+	trailer = binary.BigEndian.AppendUint32(trailer, b.sum)
+	_, err := b.w.Write(trailer)
+	return err
+}
+
+// Reads the blocks written by a blockWriter, checking each one and the trailer
+type blockReader struct {
+	r    io.Reader
+	buf  []byte // The block being read
+	off  int    // How much of buf was read
+	sum  uint32 // The checksum of every block read so far
+	n    uint64 // The length of every block read so far
+	done bool   // The trailer was read and checked
+	err  error  // Why the blocks couldn't be read, returned from then on
+}
+
+// Read the next bytes of the current block, moving to the next one when it runs out
+func (b *blockReader) Read(p []byte) (int, error) {
+	for b.off == len(b.buf) {
+		if b.err != nil {
+			return 0, b.err
+		}
+		if b.done {
+			return 0, io.EOF
+		}
+		b.err = b.next()
+	}
+	n := copy(p, b.buf[b.off:])
+	b.off += n
+	return n, nil
+}
+
+// Read and check the next block, or the trailer once the blocks end
+func (b *blockReader) next() error {
+	var head [4]byte
+	if _, err := io.ReadFull(b.r, head[:]); err != nil {
+		return truncated(err)
+	}
+	size := binary.BigEndian.Uint32(head[:])
+	if size == 0 {
+		var trailer [12]byte
+		if _, err := io.ReadFull(b.r, trailer[:]); err != nil {
+			return truncated(err)
+		}
+		if binary.BigEndian.Uint64(trailer[:8]) != b.n || binary.BigEndian.Uint32(trailer[8:]) != b.sum {
+			return fmt.Errorf("%w: trailing checksum mismatch", ErrCorruptSnapshot)
+		}
+		b.done = true
+		return nil
+	}
+	if size > snapshotBlockSize {
+		return fmt.Errorf("%w: block of %v bytes is too large", ErrCorruptSnapshot, size)
+	}
+	block := make([]byte, size+4)
+	if _, err := io.ReadFull(b.r, block); err != nil {
+		return truncated(err)
+	}
+	data := block[:size]
+	if crc32.Checksum(data, castagnoli) != binary.BigEndian.Uint32(block[size:]) {
+		return fmt.Errorf("%w: block checksum mismatch at byte %v", ErrCorruptSnapshot, b.n)
+	}
+	b.sum = crc32.Update(b.sum, castagnoli, data)
+	b.n += uint64(size)
+	b.buf, b.off = data, 0
+	return nil
+}
+
+// Explains why reading from the blocks failed, a broken block is the real reason when there is one
+// err -> The error whatever was reading the blocks returned
+func (b *blockReader) corrupt(err error) error {
+	if b.err != nil {
+		return b.err
+	}
+	return fmt.Errorf("%w: %w", ErrCorruptSnapshot, err)
+}
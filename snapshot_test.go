@@ -0,0 +1,154 @@
+package godistcache
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"os"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/mbarreca/godistcache/godistcachetest"
+)
+
+// Creates a snapshot big enough to span several blocks
+func testSnapshot(t *testing.T) []byte {
+	t.Helper()
+	clock := godistcachetest.NewClock(time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC))
+	c := newTestTyped[string, string](t, WithClock(clock), WithInstanceID("i1"))
+	for i := 0; i < 20000; i++ {
+		c.Put(strconv.Itoa(i), "value "+strconv.Itoa(i))
+	}
+	var buf bytes.Buffer
+	if err := c.WriteSnapshot(&buf); err != nil {
+		t.Fatal(err)
+	}
+	if buf.Len() < 2*snapshotBlockSize {
+		t.Fatalf("Snapshot is too small to test blocks: %v bytes", buf.Len())
+	}
+	return buf.Bytes()
+}
+
+func TestSnapshotHeader(t *testing.T) {
+	h, err := ReadSnapshotHeader(bytes.NewReader(testSnapshot(t)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := SnapshotHeader{Version: snapshotVersion, InstanceID: "i1", Entries: 20000, Codec: CodecGob, Compression: CompressionNone}
+	if !h.Created.Equal(time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)) {
+		t.Fatalf("Unexpected creation time: %v", h.Created)
+	}
+	h.Created = time.Time{}
+	if h != want {
+		t.Fatalf("Unexpected header: %+v", h)
+	}
+}
+
+func TestSnapshotCorruption(t *testing.T) {
+	data := testSnapshot(t)
+	cases := map[string][]byte{
+		"truncated":        data[:len(data)/2],
+		"missing trailer":  data[:len(data)-4],
+		"header flipped":   flipByte(data, len(snapshotMagic)+5),
+		"block flipped":    flipByte(data, len(data)/2),
+		"trailer flipped":  flipByte(data, len(data)-1),
+		"empty":            nil,
+		"bad magic number": flipByte(data, 3),
+	}
+	for name, b := range cases {
+		c := newTestTyped[string, string](t)
+		c.Put("existing", "kept")
+		if err := c.ReadSnapshot(bytes.NewReader(b)); !errors.Is(err, ErrCorruptSnapshot) {
+			t.Fatalf("%v snapshot returned %v", name, err)
+		}
+		// Nothing is loaded from a corrupt snapshot
+		if c.Count() != 1 || !c.Exists("existing") {
+			t.Fatalf("%v snapshot changed the cache", name)
+		}
+	}
+	c := newTestTyped[string, string](t)
+	if err := c.ReadSnapshot(bytes.NewReader(data)); err != nil || c.Count() != 20000 {
+		t.Fatalf("Intact snapshot failed to load: %v, %v items", err, c.Count())
+	}
+}
+
+// Returns a copy of b with one byte changed
+func flipByte(b []byte, i int) []byte {
+	b = bytes.Clone(b)
+	b[i] ^= 0xFF
+	return b
+}
+
+func TestSnapshotCompression(t *testing.T) {
+	plain := testSnapshot(t)
+	for _, comp := range []Compression{CompressionGzip, CompressionZstd, CompressionS2} {
+		c := newTestTyped[string, string](t, WithCompression(comp))
+		for i := 0; i < 20000; i++ {
+			c.Put(strconv.Itoa(i), "value "+strconv.Itoa(i))
+		}
+		fpwd := t.TempDir() + "/compressed"
+		if err := c.SaveToBinaryFile(fpwd); err != nil {
+			t.Fatal(err)
+		}
+		data, err := os.ReadFile(fpwd + fileExtension)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(data) >= len(plain) {
+			t.Fatalf("%v snapshot isn't smaller: %v bytes, %v uncompressed", comp, len(data), len(plain))
+		}
+		if h, err := ReadSnapshotHeader(bytes.NewReader(data)); err != nil || h.Compression != comp {
+			t.Fatalf("%v wasn't recorded in the header: %v, %v", comp, h.Compression, err)
+		}
+		// Loading detects the compression on its own
+		c2 := newTestTyped[string, string](t)
+		if err := c2.LoadFromBinary(fpwd); err != nil {
+			t.Fatal(err)
+		}
+		if v, ok := c2.Get("19999"); !ok || v != "value 19999" || c2.Count() != 20000 {
+			t.Fatalf("%v snapshot didn't load: %v items", comp, c2.Count())
+		}
+		if err := c2.ReadSnapshot(bytes.NewReader(flipByte(data, len(data)/2))); !errors.Is(err, ErrCorruptSnapshot) {
+			t.Fatalf("Corrupt %v snapshot returned %v", comp, err)
+		}
+	}
+	if _, err := New(context.Background(), WithCompression(Compression(99))); err == nil {
+		t.Fatalf("Unknown compression was accepted")
+	}
+}
+
+func TestSnapshotConsistent(t *testing.T) {
+	c := newTestTyped[int, int](t, WithShards(64))
+	var buf bytes.Buffer
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		// Keys land in every shard, each one written after the one before it
+		for i := 0; i < 20000; i++ {
+			c.Put(i, i)
+		}
+	}()
+	for running := true; running; {
+		select {
+		case <-done:
+			running = false
+		default:
+		}
+		buf.Reset()
+		if err := c.WriteSnapshot(&buf); err != nil {
+			t.Fatal(err)
+		}
+		c2 := newTestTyped[int, int](t)
+		if err := c2.ReadSnapshot(&buf); err != nil {
+			t.Fatal(err)
+		}
+		// A snapshot holding a key holds every key written before it
+		n := c2.Count()
+		for i := 0; i < n; i++ {
+			if !c2.Exists(i) {
+				t.Fatalf("Snapshot of %v items is missing %v", n, i)
+			}
+		}
+	}
+}
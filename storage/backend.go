@@ -0,0 +1,28 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"io"
+)
+
+// Returned by Get when there is nothing stored under the key
+var ErrNotExist = errors.New("storage: object doesn't exist")
+
+// Somewhere cache files can be saved to and loaded from
+type Backend interface {
+	// Store everything read from r under the key, replacing what was there
+	Put(ctx context.Context, key string, r io.Reader) error
+	// Read what is stored under the key, ErrNotExist if there is nothing, the caller closes it
+	Get(ctx context.Context, key string) (io.ReadCloser, error)
+	// Returns every key that starts with the prefix, sorted
+	List(ctx context.Context, prefix string) ([]string, error)
+	// Remove what is stored under the key, removing a key that doesn't exist isn't an error
+	Delete(ctx context.Context, key string) error
+}
+
+// Implemented by backends that can copy an object without reading it back, like S3
+type Copier interface {
+	// Copy what is stored under src to dst
+	Copy(ctx context.Context, src, dst string) error
+}
@@ -0,0 +1,93 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"io"
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+	"testing/iotest"
+)
+
+// Checks the behavior every Backend has to share
+func testBackend(t *testing.T, b Backend) {
+	ctx := context.Background()
+	if _, err := b.Get(ctx, "missing"); !errors.Is(err, ErrNotExist) {
+		t.Fatalf("Get of a missing key returned %v", err)
+	}
+	for _, key := range []string{"b.godistcache", "a.godistcache", "dir/c.godistcache"} {
+		if err := b.Put(ctx, key, strings.NewReader("value "+key)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// Put replaces what was there
+	if err := b.Put(ctx, "a.godistcache", strings.NewReader("new")); err != nil {
+		t.Fatal(err)
+	}
+	r, err := b.Get(ctx, "a.godistcache")
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := io.ReadAll(r)
+	r.Close()
+	if err != nil || string(data) != "new" {
+		t.Fatalf("Get returned %q, %v", data, err)
+	}
+	keys, err := b.List(ctx, "")
+	if want := []string{"a.godistcache", "b.godistcache", "dir/c.godistcache"}; err != nil || !reflect.DeepEqual(keys, want) {
+		t.Fatalf("List returned %v, %v", keys, err)
+	}
+	keys, err = b.List(ctx, "dir/")
+	if want := []string{"dir/c.godistcache"}; err != nil || !reflect.DeepEqual(keys, want) {
+		t.Fatalf("List with a prefix returned %v, %v", keys, err)
+	}
+	if err := b.Delete(ctx, "a.godistcache"); err != nil {
+		t.Fatal(err)
+	}
+	if err := b.Delete(ctx, "a.godistcache"); err != nil {
+		t.Fatalf("Deleting a missing key returned %v", err)
+	}
+	if _, err := b.Get(ctx, "a.godistcache"); !errors.Is(err, ErrNotExist) {
+		t.Fatalf("Get of a deleted key returned %v", err)
+	}
+}
+
+func TestMemory(t *testing.T) {
+	testBackend(t, NewMemory())
+}
+
+func TestFS(t *testing.T) {
+	b, err := NewFS(t.TempDir() + "/backend")
+	if err != nil {
+		t.Fatal(err)
+	}
+	testBackend(t, b)
+	if err := b.Put(context.Background(), "../escape", strings.NewReader("")); err == nil {
+		t.Fatalf("Put accepted a key outside the directory")
+	}
+	// An upload that fails halfway keeps the previous content and leaves no temporary file
+	broken := io.MultiReader(strings.NewReader("partial"), iotest.ErrReader(errors.New("encoder failed")))
+	if err := b.Put(context.Background(), "b.godistcache", broken); err == nil {
+		t.Fatalf("Put of a failing reader succeeded")
+	}
+	r, err := b.Get(context.Background(), "b.godistcache")
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, _ := io.ReadAll(r)
+	r.Close()
+	if string(data) != "value b.godistcache" {
+		t.Fatalf("Failed Put replaced the content with %q", data)
+	}
+	entries, err := os.ReadDir(b.Dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, e := range entries {
+		if strings.HasSuffix(e.Name(), tempSuffix) {
+			t.Fatalf("Failed Put left %v behind", e.Name())
+		}
+	}
+}
@@ -0,0 +1,145 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"strings"
+)
+
+// A Backend that stores every key as a file under a directory
+type FS struct {
+	Dir string // The directory the files are stored in
+}
+
+// Create a new filesystem backend, creating the directory if it doesn't exist
+// dir -> The directory to store the files in
+func NewFS(dir string) (*FS, error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, err
+	}
+	return &FS{Dir: dir}, nil
+}
+
+// Returns the path of the file a key is stored in
+// key -> The key, "/" separates directories
+func (b *FS) path(key string) (string, error) {
+	if !filepath.IsLocal(filepath.FromSlash(key)) {
+		return "", fmt.Errorf("storage: invalid key %q", key)
+	}
+	return filepath.Join(b.Dir, filepath.FromSlash(key)), nil
+}
+
+// Store everything read from r under the key
+// The content goes to a temporary file that only replaces the old one once all of it was written and synced, so a failed upload keeps what was there
+// key -> The key to store under
+// r -> The content
+func (b *FS) Put(ctx context.Context, key string, r io.Reader) (err error) {
+	path, err := b.path(key)
+	if err != nil {
+		return err
+	}
+	dir := filepath.Dir(path)
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return err
+	}
+	file, err := os.CreateTemp(dir, "."+filepath.Base(path)+".*"+tempSuffix)
+	if err != nil {
+		return err
+	}
+	// Leave nothing behind if anything fails
+	defer func() {
+		if err != nil {
+			file.Close()
+			os.Remove(file.Name())
+		}
+	}()
+	if _, err := io.Copy(file, r); err != nil {
+		return err
+	}
+	if err := file.Chmod(0o644); err != nil {
+		return err
+	}
+	if err := file.Sync(); err != nil {
+		return err
+	}
+	if err := file.Close(); err != nil {
+		return err
+	}
+	if err := os.Rename(file.Name(), path); err != nil {
+		return err
+	}
+	return syncDir(dir)
+}
+
+// Ends the names of files still being written by Put, List leaves them out
+const tempSuffix = ".tmp"
+
+// Flush a rename in dir to disk
+// dir -> The directory holding the renamed file
+func syncDir(dir string) error {
+	// Windows can't open a directory to sync it
+	if runtime.GOOS == "windows" {
+		return nil
+	}
+	d, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	defer d.Close()
+	return d.Sync()
+}
+
+// Read what is stored under the key
+// key -> The key to read
+func (b *FS) Get(ctx context.Context, key string) (io.ReadCloser, error) {
+	path, err := b.path(key)
+	if err != nil {
+		return nil, err
+	}
+	file, err := os.Open(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, ErrNotExist
+	}
+	return file, err
+}
+
+// Returns every key that starts with the prefix, sorted
+// prefix -> The prefix to match, "" lists everything
+func (b *FS) List(ctx context.Context, prefix string) ([]string, error) {
+	var keys []string
+	err := filepath.WalkDir(b.Dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() || strings.HasSuffix(d.Name(), tempSuffix) {
+			return err
+		}
+		rel, err := filepath.Rel(b.Dir, path)
+		if err != nil {
+			return err
+		}
+		if key := filepath.ToSlash(rel); strings.HasPrefix(key, prefix) {
+			keys = append(keys, key)
+		}
+		return nil
+	})
+	sort.Strings(keys)
+	return keys, err
+}
+
+// Remove what is stored under the key
+// key -> The key to remove
+func (b *FS) Delete(ctx context.Context, key string) error {
+	path, err := b.path(key)
+	if err != nil {
+		return err
+	}
+	if err := os.Remove(path); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,71 @@
+package storage
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// A Backend that keeps everything in memory, for tests
+type Memory struct {
+	m       sync.RWMutex
+	objects map[string][]byte
+}
+
+// Create a new empty in-memory backend
+func NewMemory() *Memory {
+	return &Memory{objects: make(map[string][]byte)}
+}
+
+// Store everything read from r under the key
+// key -> The key to store under
+// r -> The content
+func (b *Memory) Put(ctx context.Context, key string, r io.Reader) error {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return err
+	}
+	b.m.Lock()
+	b.objects[key] = data
+	b.m.Unlock()
+	return nil
+}
+
+// Read what is stored under the key
+// key -> The key to read
+func (b *Memory) Get(ctx context.Context, key string) (io.ReadCloser, error) {
+	b.m.RLock()
+	data, ok := b.objects[key]
+	b.m.RUnlock()
+	if !ok {
+		return nil, ErrNotExist
+	}
+	return io.NopCloser(bytes.NewReader(data)), nil
+}
+
+// Returns every key that starts with the prefix, sorted
+// prefix -> The prefix to match, "" lists everything
+func (b *Memory) List(ctx context.Context, prefix string) ([]string, error) {
+	b.m.RLock()
+	defer b.m.RUnlock()
+	var keys []string
+	for k := range b.objects {
+		if strings.HasPrefix(k, prefix) {
+			keys = append(keys, k)
+		}
+	}
+	sort.Strings(keys)
+	return keys, nil
+}
+
+// Remove what is stored under the key
+// key -> The key to remove
+func (b *Memory) Delete(ctx context.Context, key string) error {
+	b.m.Lock()
+	delete(b.objects, key)
+	b.m.Unlock()
+	return nil
+}
@@ -14,37 +14,82 @@ import (
 
 // S3 Object
 type S3 struct {
-	Bucket string
-	Client *minio.Client
-	Ctx    context.Context
+	Bucket     string
+	Client     *minio.Client
+	Ctx        context.Context
+	Now        func() time.Time // Returns the current time, used to name files and daily backups
+	InstanceID string           // Names this instance's daily backups so instances don't overwrite each other
+	PartSize   uint64           // The size of each part of a multipart upload, DefaultPartSize when 0
 }
 
-// Create a new S3 Object
-// ctx - Pass your telemetry context here
-func New(ctx context.Context) (*S3, error) {
+// The part size used by Put when the S3 object doesn't set one
+// Each upload buffers one part in memory, so this bounds what a save costs no matter the size of the cache
+const DefaultPartSize = 16 << 20
+
+// How to connect to an S3 compatible store
+type Config struct {
+	Endpoint  string // The host, e.g. region.domain.com
+	AccessKey string
+	SecretKey string
+	Bucket    string
+	SSL       bool // Connect over HTTPS
+}
+
+// Reads the config from the GODISTCACHE_S3_* environment variables
+func ConfigFromEnv() (Config, error) {
 	// Check to see if SSL is enabled with S3
 	ssl, err := strconv.ParseBool(os.Getenv("GODISTCACHE_S3_SSL"))
+	if err != nil {
+		return Config{}, err
+	}
+	return Config{
+		Endpoint:  os.Getenv("GODISTCACHE_S3_ENDPOINT"),
+		AccessKey: os.Getenv("GODISTCACHE_S3_ACCESS_KEY"),
+		SecretKey: os.Getenv("GODISTCACHE_S3_SECRET_KEY"),
+		Bucket:    os.Getenv("GODISTCACHE_S3_BUCKET"),
+		SSL:       ssl,
+	}, nil
+}
+
+// Create a new S3 Object from the environment variables
+// ctx - Pass your telemetry context here
+func New(ctx context.Context) (*S3, error) {
+	cfg, err := ConfigFromEnv()
 	if err != nil {
 		return nil, err
 	}
+	s3, err := NewWithConfig(ctx, cfg)
+	if err != nil {
+		return nil, err
+	}
+	s3.InstanceID = os.Getenv("GODISTCACHE_INSTANCE_ID")
+	return s3, nil
+}
+
+// Create a new S3 Object
+// ctx - Pass your telemetry context here
+// cfg - How to connect
+func NewWithConfig(ctx context.Context, cfg Config) (*S3, error) {
 	// Create new S3 client
-	client, err := minio.New(os.Getenv("GODISTCACHE_S3_ENDPOINT"), &minio.Options{
-		Creds:  credentials.NewStaticV4(os.Getenv("GODISTCACHE_S3_ACCESS_KEY"), os.Getenv("GODISTCACHE_S3_SECRET_KEY"), ""),
-		Secure: ssl,
+	client, err := minio.New(cfg.Endpoint, &minio.Options{
+		Creds:  credentials.NewStaticV4(cfg.AccessKey, cfg.SecretKey, ""),
+		Secure: cfg.SSL,
 	})
 	if err != nil {
 		return nil, err
 	}
 	return &S3{
-		Bucket: os.Getenv("GODISTCACHE_S3_BUCKET"),
+		Bucket: cfg.Bucket,
 		Client: client,
 		Ctx:    ctx,
+		Now:    time.Now,
 	}, nil
 }
 
 // This will download a file from an S3 compatible storage server
 // key -> The objects key in S3 -> Do not include the .godistcache extension
 // This was tested with SeaweedFS S3
+// Deprecated: Use Get, which streams the object without writing it to disk
 func (s3 *S3) S3Download(key string) (string, error) {
 	// Get the Object from S3
 	object, err := s3.Client.GetObject(s3.Ctx, s3.Bucket, key, minio.GetObjectOptions{})
@@ -58,7 +103,7 @@ func (s3 *S3) S3Download(key string) (string, error) {
 		return "", err
 	}
 	// Get current time and path
-	t := strconv.FormatInt(time.Now().UTC().Unix(), 10)
+	t := strconv.FormatInt(s3.Now().UTC().Unix(), 10)
 	path := pwd + "/" + t
 	cacheFile, err := os.Create(path + ".godistcache")
 	if err != nil {
@@ -75,11 +120,12 @@ func (s3 *S3) S3Download(key string) (string, error) {
 // This will upload the file to S3 to the master file as we as the current days backup under the current instance
 // filePathName -> The path with the filename - DO NOT add the extension .godistcache
 // key -> The objects key in S3 -> Do not include the .godistcache extension
+// Deprecated: Use Put, which streams the object without reading it from disk
 func (s3 *S3) S3Upload(filePathName, key string) error {
 	if s3.Bucket == "" {
 		return errors.New("Bucket is nil")
 	}
-	id := os.Getenv("GODISTCACHE_INSTANCE_ID")
+	id := s3.InstanceID
 	file, err := os.Open(filePathName + ".godistcache")
 	if err != nil {
 		return err
@@ -90,7 +136,7 @@ func (s3 *S3) S3Upload(filePathName, key string) error {
 	if err != nil {
 		return err
 	}
-	t := time.Now().Format("01-02-2006")
+	t := s3.Now().Format("01-02-2006")
 	// Create an entry for today
 	_, err = s3.Client.PutObject(s3.Ctx, s3.Bucket, key+"_"+id+"_"+t+".godistcache", file, fileStat.Size(), minio.PutObjectOptions{ContentType: "application/octet-stream"})
 	if err != nil {
@@ -98,11 +144,11 @@ func (s3 *S3) S3Upload(filePathName, key string) error {
 	}
 	// Copy to "Master"
 	src := minio.CopySrcOptions{
-		Bucket: os.Getenv("GODISTCACHE_S3_BUCKET"),
+		Bucket: s3.Bucket,
 		Object: key + "_" + id + "_" + t + ".godistcache",
 	}
 	dst := minio.CopyDestOptions{
-		Bucket: os.Getenv("GODISTCACHE_S3_BUCKET"),
+		Bucket: s3.Bucket,
 		Object: key + ".godistcache",
 	}
 	_, err = s3.Client.CopyObject(s3.Ctx, dst, src)
@@ -111,3 +157,76 @@ func (s3 *S3) S3Upload(filePathName, key string) error {
 	}
 	return nil
 }
+
+// Store everything read from r under the key
+// key -> The objects key in S3
+// r -> The content, streamed as a multipart upload since its size isn't known up front
+func (s3 *S3) Put(ctx context.Context, key string, r io.Reader) error {
+	if s3.Bucket == "" {
+		return errors.New("Bucket is nil")
+	}
+	partSize := s3.PartSize
+	if partSize == 0 {
+		partSize = DefaultPartSize
+	}
+	_, err := s3.Client.PutObject(ctx, s3.Bucket, key, r, -1, minio.PutObjectOptions{ContentType: "application/octet-stream", PartSize: partSize})
+	return err
+}
+
+// Read what is stored under the key
+// key -> The objects key in S3
+func (s3 *S3) Get(ctx context.Context, key string) (io.ReadCloser, error) {
+	if s3.Bucket == "" {
+		return nil, errors.New("Bucket is nil")
+	}
+	object, err := s3.Client.GetObject(ctx, s3.Bucket, key, minio.GetObjectOptions{})
+	if err != nil {
+		return nil, err
+	}
+	// GetObject is lazy, Stat finds out if the object exists
+	if _, err := object.Stat(); err != nil {
+		object.Close()
+		if minio.ToErrorResponse(err).Code == "NoSuchKey" {
+			return nil, ErrNotExist
+		}
+		return nil, err
+	}
+	return object, nil
+}
+
+// Returns every key that starts with the prefix, sorted
+// prefix -> The prefix to match, "" lists everything
+func (s3 *S3) List(ctx context.Context, prefix string) ([]string, error) {
+	if s3.Bucket == "" {
+		return nil, errors.New("Bucket is nil")
+	}
+	var keys []string
+	for object := range s3.Client.ListObjects(ctx, s3.Bucket, minio.ListObjectsOptions{Prefix: prefix, Recursive: true}) {
+		if object.Err != nil {
+			return nil, object.Err
+		}
+		keys = append(keys, object.Key)
+	}
+	return keys, nil
+}
+
+// Remove what is stored under the key
+// key -> The objects key in S3
+func (s3 *S3) Delete(ctx context.Context, key string) error {
+	if s3.Bucket == "" {
+		return errors.New("Bucket is nil")
+	}
+	return s3.Client.RemoveObject(ctx, s3.Bucket, key, minio.RemoveObjectOptions{})
+}
+
+// Copy an object inside the bucket without downloading it
+// CopyObject is limited to 5 GiB, ComposeObject copies larger objects in parts
+// src -> The key to copy from
+// dst -> The key to copy to
+func (s3 *S3) Copy(ctx context.Context, src, dst string) error {
+	if s3.Bucket == "" {
+		return errors.New("Bucket is nil")
+	}
+	_, err := s3.Client.ComposeObject(ctx, minio.CopyDestOptions{Bucket: s3.Bucket, Object: dst}, minio.CopySrcOptions{Bucket: s3.Bucket, Object: src})
+	return err
+}
@@ -0,0 +1,596 @@
+package godistcache
+
+import (
+	"hash/maphash"
+	"runtime"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// The smallest share of a limit each shard gets when the shard count is picked automatically
+const (
+	minShardEntries = 1024
+	minShardBytes   = 1 << 20
+)
+
+// This is the generic storage engine shared by Cache and TypedCache
+// Items are spread over hash-sharded segments so operations on different keys don't contend on one lock
+type store[K comparable, V any] struct {
+	shards []*shard[K, V] // The segments, always a power of two
+	mask   uint64         // Selects a shard from a hash
+	seed   maphash.Seed   // Seeds the key hash
+	defTTL time.Duration  // Default time to live, NoExpiration never expires
+	grace  int64          // How long stale items are served while they are refreshed, in nanoseconds
+	ahead  float64        // Fraction of the lifetime at the end of which reads refresh an item, 0 disables it
+	slide  bool           // Every read extends the expiration of the item by its TTL
+	clock  Clock          // Tells the time
+
+	max       int                         // Maximum amount of items per shard, 0 is unlimited
+	maxBytes  int64                       // Maximum estimated size of all items per shard, 0 is unlimited
+	sizer     Sizer                       // Estimates the size of an item, nil when sizes aren't tracked
+	newPolicy func() EvictionPolicy       // Creates the eviction policy of each shard
+	evictions atomic.Uint64               // Amount of items evicted because the store was full
+	onEvict   func(key any, total uint64) // Called after each eviction, outside the lock
+
+	hooks atomic.Pointer[hooks[K, V]] // Callbacks for items entering and leaving the store
+	hm    sync.Mutex                  // Serializes registering callbacks
+
+	flights  flightGroup[K, V] // Loads in progress for GetOrLoad
+	negative *store[K, error]  // Errors returned by loaders, nil when they aren't cached
+
+	wal *wal[K, V] // Records every write, nil without WithWAL
+
+	track   bool        // Keys changed since the last upload are kept in each shard's dirty set
+	cleared atomic.Bool // Changes since the last upload can't be told apart by key, the next upload has to be a full snapshot
+
+	stop     chan struct{} // Closed to stop the janitor
+	stopOnce sync.Once     // Makes stopping the janitor safe to repeat
+}
+
+// One segment of the store with its own lock
+type shard[K comparable, V any] struct {
+	m      sync.RWMutex   // Used to prevent collisions
+	items  map[K]entry[V] // Where the items are stored
+	bytes  int64          // Current estimated size of all items
+	pm     sync.Mutex     // Guards the eviction policy, which is also updated on reads
+	policy EvictionPolicy // Picks the item to evict, nil when unlimited
+	dirty  map[K]struct{} // Keys changed since the last upload, nil when they aren't tracked
+}
+
+// This object is internally what exists in each item of the store
+type entry[V any] struct {
+	v     V             // The item to store
+	e     int64         // Expiration timestamp in Unix UTC nanoseconds, the item is deleted after it
+	soft  int64         // Timestamp in Unix UTC nanoseconds after which the item is stale, equal to e without a grace period
+	born  int64         // Timestamp in Unix UTC nanoseconds of when the item was stored
+	slide time.Duration // Sliding expiration, reads move the expiration this far from now, 0 is fixed
+	size  int64         // Estimated size in bytes, 0 when sizes aren't tracked
+}
+
+// Creates a new store
+// ttl -> The default time to live, NoExpiration or DefaultExpiration never expire
+// o -> The options the store was created with
+func newStore[K comparable, V any](ttl time.Duration, o options) *store[K, V] {
+	if ttl == DefaultExpiration {
+		ttl = NoExpiration
+	}
+	s := &store[K, V]{seed: maphash.MakeSeed(), defTTL: ttl, grace: int64(o.grace), ahead: o.ahead, slide: o.sliding, clock: o.clock, sizer: o.sizer, newPolicy: o.newPolicy, onEvict: o.onEvict, track: o.fullEvery > 0}
+	if o.maxBytes > 0 && s.sizer == nil {
+		s.sizer = ReflectSizer
+	}
+	n := shardCount(o)
+	s.shards = make([]*shard[K, V], n)
+	s.mask = uint64(n - 1)
+	// Split the limits between the shards
+	if o.maxEntries > 0 {
+		s.max = max(o.maxEntries/n, 1)
+	}
+	if o.maxBytes > 0 {
+		s.maxBytes = max(o.maxBytes/int64(n), 1)
+	}
+	for i := range s.shards {
+		s.shards[i] = &shard[K, V]{items: make(map[K]entry[V])}
+		if s.bounded() {
+			s.shards[i].policy = s.newPolicy()
+		}
+		if s.track {
+			s.shards[i].dirty = make(map[K]struct{})
+		}
+	}
+	s.hooks.Store(&hooks[K, V]{})
+	if o.negativeTTL > 0 {
+		s.negative = newStore[K, error](o.negativeTTL, options{shards: 1, maxEntries: o.maxEntries, newPolicy: NewLRU, clock: o.clock})
+	}
+	s.stop = make(chan struct{})
+	if o.sweepInterval > 0 {
+		go s.janitor(o.sweepInterval, o.sweepKeys)
+	}
+	return s
+}
+
+// Picks the amount of shards, rounded up to a power of two
+// Defaults to 4 per GOMAXPROCS, fewer when a limit is set so each shard keeps a useful share of it
+func shardCount(o options) int {
+	n := o.shards
+	if n <= 0 {
+		n = 4 * runtime.GOMAXPROCS(0)
+		if o.maxEntries > 0 {
+			n = min(n, o.maxEntries/minShardEntries)
+		}
+		if o.maxBytes > 0 {
+			n = min(n, int(o.maxBytes/minShardBytes))
+		}
+	}
+	// Every shard needs room for at least one item
+	if o.maxEntries > 0 {
+		n = min(n, o.maxEntries)
+	}
+	p := 1
+	for p < n {
+		p <<= 1
+	}
+	// Rounding up can't break the limit
+	if o.maxEntries > 0 && p > o.maxEntries {
+		p >>= 1
+	}
+	return p
+}
+
+// Returns the shard the key belongs to
+// key -> The key to lookup in the store
+func (s *store[K, V]) shardFor(key K) *shard[K, V] {
+	if s.mask == 0 {
+		return s.shards[0]
+	}
+	return s.shards[hashKey(s.seed, key)&s.mask]
+}
+
+// Tells you whether or not the store has a limit and needs an eviction policy
+func (s *store[K, V]) bounded() bool {
+	return s.max > 0 || s.maxBytes > 0
+}
+
+// Add an item to the store
+// key -> The key to lookup in the store
+// value -> The value to store
+// ttl -> The time to live, DefaultExpiration uses the store's and NoExpiration never expires
+func (s *store[K, V]) put(key K, value V, ttl time.Duration) {
+	var slide time.Duration
+	if s.slide {
+		slide = s.resolve(ttl)
+	}
+	s.putAt(key, value, s.expiry(ttl), slide)
+}
+
+// Add an item to the store with a sliding expiration
+// key -> The key to lookup in the store
+// value -> The value to store
+// ttl -> The time to live from now and from every read, DefaultExpiration uses the store's
+func (s *store[K, V]) putSliding(key K, value V, ttl time.Duration) {
+	s.putAt(key, value, s.expiry(ttl), s.resolve(ttl))
+}
+
+// Add an item to the store that turns stale at the given timestamp
+// key -> The key to lookup in the store
+// value -> The value to store
+// soft -> The expiration timestamp in Unix UTC nanoseconds, noExpiry never expires
+// slide -> How far from now each read moves the expiration, 0 or NoExpiration is a fixed expiration
+func (s *store[K, V]) putAt(key K, value V, soft int64, slide time.Duration) {
+	var size int64
+	if s.sizer != nil {
+		size = s.sizer(key, value)
+	}
+	sh := s.shardFor(key)
+	sh.m.Lock()
+	removed, stored := s.setLocked(sh, key, value, soft, slide, size)
+	sh.m.Unlock()
+	s.reportRemovals(removed)
+	s.reportInsertions(stored)
+}
+
+// Store an item in its shard, returns what has to be reported once the lock is released, must hold the shard lock
+// sh -> The shard of the key
+// key -> The key to lookup in the store
+// value -> The value to store
+// soft -> The expiration timestamp in Unix UTC nanoseconds, noExpiry never expires
+// slide -> How far from now each read moves the expiration, 0 or NoExpiration is a fixed expiration
+// size -> The estimated size of the item
+func (s *store[K, V]) setLocked(sh *shard[K, V], key K, value V, soft int64, slide time.Duration, size int64) ([]removal[K, V], insertion[K, V]) {
+	if slide < 0 {
+		slide = 0
+	}
+	var removed []removal[K, V]
+	old, exists := sh.items[key]
+	// Replacing an expired item counts as an insert, the clock is only read when it can tell
+	update := exists && (old.e == noExpiry || old.e >= s.now())
+	// Only refresh-ahead needs to know when the item was stored
+	var born int64
+	if s.ahead > 0 {
+		born = s.now()
+	}
+	if exists && !update {
+		removed = append(removed, removal[K, V]{key: key, v: old.v, reason: EvictExpired})
+	}
+	if sh.policy != nil {
+		sh.pm.Lock()
+		if exists {
+			// Make room for the growth of the item, without evicting the item itself
+			sh.policy.Access(key)
+			removed = append(removed, s.evict(sh, 0, size-old.size, &key)...)
+		} else {
+			// Make room for the new item
+			removed = append(removed, s.evict(sh, 1, size, nil)...)
+			sh.policy.Add(key)
+		}
+		sh.pm.Unlock()
+	}
+	sh.bytes += size - old.size
+	sh.items[key] = entry[V]{v: value, e: s.hardExpiry(soft), soft: soft, born: born, slide: slide, size: size}
+	for _, r := range removed {
+		if r.reason == EvictCapacity {
+			s.wal.logDelete(r.key)
+			sh.touched(r.key)
+		}
+	}
+	s.wal.logPut(key, value, soft, slide)
+	sh.touched(key)
+	return removed, insertion[K, V]{key: key, old: old.v, v: value, update: update}
+}
+
+// Evict items until n more items and size more bytes fit within the shard's limits, must hold both shard locks
+// An item larger than the whole byte budget evicts everything else and is then stored anyway
+// sh -> The shard to evict from
+// n -> The amount of items about to be added
+// size -> The amount of bytes about to be added
+// keep -> A key that must not be evicted, nil if there is none
+func (s *store[K, V]) evict(sh *shard[K, V], n int, size int64, keep *K) []removal[K, V] {
+	var evicted []removal[K, V]
+	for (s.max > 0 && len(sh.items)+n > s.max) || (s.maxBytes > 0 && sh.bytes+size > s.maxBytes) {
+		victim, ok := sh.policy.Victim()
+		if !ok || (keep != nil && victim.(K) == *keep) {
+			break
+		}
+		k := victim.(K)
+		sh.policy.Remove(victim)
+		e := sh.items[k]
+		sh.bytes -= e.size
+		delete(sh.items, k)
+		evicted = append(evicted, removal[K, V]{key: k, v: e.v, reason: EvictCapacity})
+	}
+	return evicted
+}
+
+// Get an item from the store, expired items are deleted and reported as missing
+// key -> The key to lookup in the store
+func (s *store[K, V]) get(key K) (V, bool) {
+	v, ok, _ := s.lookup(key)
+	return v, ok
+}
+
+// Get an item from the store and tell apart missing and expired items, expired items are deleted
+// key -> The key to lookup in the store
+func (s *store[K, V]) lookup(key K) (value V, ok bool, expired bool) {
+	return s.lookupWith(key, s.callbacks().refresh)
+}
+
+// Get an item from the store, refreshing it in the background when it's stale or about to be
+// Without a refresher stale items are reported as expired, they are deleted once their grace period is over
+// key -> The key to lookup in the store
+// r -> Reloads the item, nil if there is none
+func (s *store[K, V]) lookupWith(key K, r Refresher[K, V]) (value V, ok bool, expired bool) {
+	sh := s.shardFor(key)
+	sh.m.RLock()
+	v, ok := sh.items[key]
+	sh.m.RUnlock()
+	if !ok {
+		return value, false, false
+	}
+	// Read the clock once and only for items that expire, it costs more than the map lookup
+	var now int64
+	if v.soft != noExpiry || r != nil {
+		now = s.now()
+	}
+	// Check if the key has expired, if so delete
+	if v.e < now {
+		s.expire(key)
+		return value, false, true
+	}
+	if r == nil {
+		if v.soft < now {
+			return value, false, true
+		}
+	} else if s.needsRefresh(v, now) {
+		s.refresh(key, r)
+	}
+	if v.slide > 0 {
+		s.touch(key, v.slide, true)
+	}
+	if s.bounded() {
+		sh.pm.Lock()
+		sh.policy.Access(key)
+		sh.pm.Unlock()
+	}
+	return v.v, true, false
+}
+
+// Delete an item from the store, returns true if it was there
+// key -> The key to lookup in the store
+func (s *store[K, V]) delete(key K) bool {
+	sh := s.shardFor(key)
+	sh.m.Lock()
+	old, existed := sh.remove(key)
+	if existed {
+		s.wal.logDelete(key)
+		sh.touched(key)
+	}
+	sh.m.Unlock()
+	if existed {
+		s.reportRemovals([]removal[K, V]{{key: key, v: old.v, reason: EvictDeleted}})
+	}
+	return existed
+}
+
+// Delete an item if it is still expired, it may have been replaced since it was read
+// key -> The key to lookup in the store
+func (s *store[K, V]) expire(key K) {
+	sh := s.shardFor(key)
+	sh.m.Lock()
+	v, ok := sh.items[key]
+	if !ok || !s.isExpired(v.e) {
+		sh.m.Unlock()
+		return
+	}
+	sh.remove(key)
+	sh.m.Unlock()
+	s.reportRemovals([]removal[K, V]{{key: key, v: v.v, reason: EvictExpired}})
+}
+
+// Remove an item from the shard and its eviction policy, must hold the shard lock
+// Returns the removed item and whether or not it existed
+// key -> The key to lookup in the shard
+func (sh *shard[K, V]) remove(key K) (entry[V], bool) {
+	v, ok := sh.items[key]
+	if !ok {
+		return v, false
+	}
+	sh.bytes -= v.size
+	delete(sh.items, key)
+	if sh.policy != nil {
+		sh.pm.Lock()
+		sh.policy.Remove(key)
+		sh.pm.Unlock()
+	}
+	return v, true
+}
+
+// Returns the amount of items in the store
+func (s *store[K, V]) count() int {
+	count := 0
+	for _, sh := range s.shards {
+		sh.m.RLock()
+		count += len(sh.items)
+		sh.m.RUnlock()
+	}
+	return count
+}
+
+// Tells you whether or not the item corresponding to the key exists
+// key -> The key to lookup in the store
+func (s *store[K, V]) exists(key K) bool {
+	sh := s.shardFor(key)
+	sh.m.RLock()
+	_, ok := sh.items[key]
+	sh.m.RUnlock()
+	return ok
+}
+
+// Remove every item from the store
+func (s *store[K, V]) clear() {
+	if s.negative != nil {
+		s.negative.clear()
+	}
+	report := s.callbacks().evicted != nil
+	if s.track {
+		// Only once the items are gone, so an upload in between can't miss them
+		defer s.cleared.Store(true)
+	}
+	if s.wal != nil {
+		s.clearLogged(report)
+		return
+	}
+	for _, sh := range s.shards {
+		var removed []removal[K, V]
+		sh.m.Lock()
+		if report {
+			removed = sh.all(EvictCleared)
+		}
+		clear(sh.items)
+		sh.bytes = 0
+		if sh.policy != nil {
+			sh.pm.Lock()
+			sh.policy = s.newPolicy()
+			sh.pm.Unlock()
+		}
+		sh.m.Unlock()
+		s.reportRemovals(removed)
+	}
+}
+
+// Remove every item from the store while holding every shard lock, so no write lands between the shards and the log
+// report -> Whether or not removals are reported
+func (s *store[K, V]) clearLogged(report bool) {
+	var removed []removal[K, V]
+	for _, sh := range s.shards {
+		sh.m.Lock()
+	}
+	for _, sh := range s.shards {
+		if report {
+			removed = append(removed, sh.all(EvictCleared)...)
+		}
+		clear(sh.items)
+		sh.bytes = 0
+		if sh.policy != nil {
+			sh.pm.Lock()
+			sh.policy = s.newPolicy()
+			sh.pm.Unlock()
+		}
+	}
+	s.wal.logClear()
+	for _, sh := range s.shards {
+		sh.m.Unlock()
+	}
+	s.reportRemovals(removed)
+}
+
+// Add a key to the dirty set when changes are tracked, must hold the shard lock
+// key -> The key that changed
+func (sh *shard[K, V]) touched(key K) {
+	if sh.dirty != nil {
+		sh.dirty[key] = struct{}{}
+	}
+}
+
+// Returns every item of the shard as a removal, must hold the shard lock
+// reason -> Why the items are leaving
+func (sh *shard[K, V]) all(reason EvictReason) []removal[K, V] {
+	removed := make([]removal[K, V], 0, len(sh.items))
+	for k, v := range sh.items {
+		removed = append(removed, removal[K, V]{key: k, v: v.v, reason: reason})
+	}
+	return removed
+}
+
+// Returns the estimated size of all items in bytes
+func (s *store[K, V]) size() int64 {
+	var bytes int64
+	for _, sh := range s.shards {
+		sh.m.RLock()
+		bytes += sh.bytes
+		sh.m.RUnlock()
+	}
+	return bytes
+}
+
+// Returns the amount of items evicted because the store was full
+func (s *store[K, V]) evictionCount() uint64 {
+	return s.evictions.Load()
+}
+
+// Visits every shard as they all were at one instant, so the store can be copied without stopping it for long
+// Every shard is read locked before the first visit and released right after its own, so writers only wait for their shard to be copied
+// and a view holding a write always holds every write that finished before it
+// visit -> Called with each shard while it's read locked
+func (s *store[K, V]) cut(visit func(sh *shard[K, V])) {
+	for _, sh := range s.shards {
+		sh.m.RLock()
+	}
+	for _, sh := range s.shards {
+		visit(sh)
+		sh.m.RUnlock()
+	}
+}
+
+// Copies the store into a map of the given item type so it can be encoded
+// The copy is a consistent view of one instant, and encoding it doesn't hold any lock
+// wrap -> Converts an entry's value, expiration in Unix UTC nanoseconds and sliding expiration into the exported item type
+func snapshot[K comparable, V any, I any](s *store[K, V], wrap func(V, int64, time.Duration) I) map[K]I {
+	m := make(map[K]I, s.count())
+	s.cut(func(sh *shard[K, V]) {
+		for k, v := range sh.items {
+			m[k] = wrap(v.v, v.soft, v.slide)
+		}
+	})
+	return m
+}
+
+// Replaces the contents of the store with the given map of items
+// unwrap -> Converts an exported item into its value, expiration in Unix UTC nanoseconds and sliding expiration
+func restore[K comparable, V any, I any](s *store[K, V], m map[K]I, unwrap func(I) (V, int64, time.Duration)) {
+	// Build the new shards without holding any lock
+	items := make([]map[K]entry[V], len(s.shards))
+	bytes := make([]int64, len(s.shards))
+	for i := range items {
+		items[i] = make(map[K]entry[V], len(m)/len(s.shards))
+	}
+	now := s.now()
+	for k, i := range m {
+		v, e, slide := unwrap(i)
+		var size int64
+		if s.sizer != nil {
+			size = s.sizer(k, v)
+		}
+		n := hashKey(s.seed, k) & s.mask
+		bytes[n] += size
+		items[n][k] = entry[V]{v: v, e: s.hardExpiry(e), soft: e, born: now, slide: slide, size: size}
+	}
+	if s.track {
+		defer s.cleared.Store(true)
+	}
+	if s.wal != nil {
+		s.restoreLogged(items, bytes)
+		return
+	}
+	for i, sh := range s.shards {
+		sh.m.Lock()
+		removed, stored := s.swapLocked(sh, items[i], bytes[i])
+		sh.m.Unlock()
+		s.reportRemovals(removed)
+		s.reportInsertions(stored...)
+	}
+}
+
+// Replaces the shards while holding every shard lock, and logs a clear followed by every restored item
+// so writes made before the load don't come back when the log is replayed
+// items -> The new items of each shard
+// bytes -> The estimated size of each shard's items
+func (s *store[K, V]) restoreLogged(items []map[K]entry[V], bytes []int64) {
+	var removed []removal[K, V]
+	var stored []insertion[K, V]
+	for _, sh := range s.shards {
+		sh.m.Lock()
+	}
+	s.wal.logClear()
+	for i, sh := range s.shards {
+		r, st := s.swapLocked(sh, items[i], bytes[i])
+		removed, stored = append(removed, r...), append(stored, st...)
+		for k, v := range sh.items {
+			s.wal.logPut(k, v.v, v.soft, v.slide)
+		}
+	}
+	for _, sh := range s.shards {
+		sh.m.Unlock()
+	}
+	s.reportRemovals(removed)
+	s.reportInsertions(stored...)
+}
+
+// Replaces the items of a shard, returns what has to be reported once the lock is released, must hold the shard lock
+// items -> The new items
+// bytes -> Their estimated size
+func (s *store[K, V]) swapLocked(sh *shard[K, V], items map[K]entry[V], bytes int64) ([]removal[K, V], []insertion[K, V]) {
+	var removed []removal[K, V]
+	var stored []insertion[K, V]
+	h := s.callbacks()
+	if h.evicted != nil {
+		removed = sh.all(EvictCleared)
+	}
+	sh.items = items
+	sh.bytes = bytes
+	if sh.policy != nil {
+		sh.pm.Lock()
+		sh.policy = s.newPolicy()
+		for k := range sh.items {
+			sh.policy.Add(k)
+		}
+		// Trim the loaded items down to the limits
+		removed = append(removed, s.evict(sh, 0, 0, nil)...)
+		sh.pm.Unlock()
+	}
+	if h.inserted != nil {
+		for k, v := range sh.items {
+			stored = append(stored, insertion[K, V]{key: k, v: v.v})
+		}
+	}
+	return removed, stored
+}
@@ -0,0 +1,278 @@
+package godistcache
+
+import (
+	"bytes"
+	"context"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+)
+
+type shardKey struct {
+	A string
+	B float64
+}
+
+func TestShardCount(t *testing.T) {
+	if n := shardCount(options{shards: 5}); n != 8 {
+		t.Fatalf("Shards weren't rounded up to a power of two: %v", n)
+	}
+	if n := shardCount(options{maxEntries: 10}); n != 1 {
+		t.Fatalf("Small limits should use one shard: %v", n)
+	}
+	if n := shardCount(options{shards: 16, maxEntries: 10}); n != 8 {
+		t.Fatalf("Every shard needs room for an item: %v", n)
+	}
+}
+
+func TestShardedConcurrency(t *testing.T) {
+	c := newTestTyped[string, int](t, WithShards(16))
+	s, _ := createObjects()
+	var wg sync.WaitGroup
+	for g := 0; g < 8; g++ {
+		wg.Add(1)
+		go func(g int) {
+			defer wg.Done()
+			for i := 0; i < amountOfRuns; i++ {
+				c.Put(s[i], i)
+				c.Get(s[(i+g)%amountOfRuns])
+				c.Exists(s[i])
+			}
+		}(g)
+	}
+	wg.Wait()
+	if c.Count() != amountOfRuns {
+		t.Fatalf("Amount of Cache Items != Amount Counted: %v", c.Count())
+	}
+	for i := 0; i < amountOfRuns; i++ {
+		if v, e := c.Get(s[i]); !e || v != i {
+			t.Fatalf("Failed reading Get on Index: %v", i)
+		}
+	}
+}
+
+func TestShardedStructKeys(t *testing.T) {
+	c := newTestTyped[shardKey, int](t, WithShards(16))
+	c.Put(shardKey{A: "a", B: 0}, 1)
+	// -0 == 0 so it must land on the same shard
+	negZero := 0.0
+	negZero = -negZero
+	if v, e := c.Get(shardKey{A: "a", B: negZero}); !e || v != 1 {
+		t.Fatalf("Equal struct keys weren't found")
+	}
+	if c.Exists(shardKey{A: "b"}) {
+		t.Fatalf("Different struct key was found")
+	}
+}
+
+func benchmarkGetParallel(b *testing.B, shards int) {
+	c := newTestTyped[string, Object](b, WithShards(shards))
+	s, objs := createObjects()
+	for i := 0; i < amountOfRuns; i++ {
+		c.Put(s[i], objs[i])
+	}
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		i := 0
+		for pb.Next() {
+			c.Get(s[i%amountOfRuns])
+			i++
+		}
+	})
+}
+
+func benchmarkPutParallel(b *testing.B, shards int) {
+	c := newTestTyped[string, Object](b, WithShards(shards))
+	s, objs := createObjects()
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		i := 0
+		for pb.Next() {
+			c.Put(s[i%amountOfRuns], objs[i%amountOfRuns])
+			i++
+		}
+	})
+}
+
+// A single shard shows what splitting the store gains, BenchmarkCompareGet and BenchmarkComparePut compare against the cache before it
+func BenchmarkGetParallel(b *testing.B)            { benchmarkGetParallel(b, 0) }
+func BenchmarkGetParallelSingleShard(b *testing.B) { benchmarkGetParallel(b, 1) }
+func BenchmarkPutParallel(b *testing.B)            { benchmarkPutParallel(b, 0) }
+func BenchmarkPutParallelSingleShard(b *testing.B) { benchmarkPutParallel(b, 1) }
+
+// The cache before the shared store, one map behind one lock with second expirations, kept to benchmark against
+type baselineCache struct {
+	m     sync.RWMutex
+	items map[string]baselineItem
+	exp   int64
+}
+
+type baselineItem struct {
+	V any
+	E int64
+}
+
+func newBaselineCache() *baselineCache {
+	return &baselineCache{items: make(map[string]baselineItem), exp: 1000 * 365 * 24 * 60 * 60}
+}
+
+func (c *baselineCache) Put(key string, value any) {
+	c.m.Lock()
+	c.items[key] = baselineItem{V: value, E: time.Now().UTC().Unix() + c.exp}
+	c.m.Unlock()
+}
+
+func (c *baselineCache) Get(key string) (any, bool) {
+	c.m.Lock()
+	v := c.items[key]
+	c.m.Unlock()
+	if v == (baselineItem{}) {
+		return nil, false
+	}
+	if v.E < time.Now().UTC().Unix() {
+		c.m.Lock()
+		delete(c.items, key)
+		c.m.Unlock()
+		return nil, false
+	}
+	return v.V, true
+}
+
+// What the benchmarks compare, the baseline and the current Cache
+type benchCache interface {
+	Put(key string, value any)
+	Get(key string) (any, bool)
+}
+
+func benchCaches(b *testing.B) map[string]func() benchCache {
+	return map[string]func() benchCache{
+		"baseline": func() benchCache { return newBaselineCache() },
+		"sharded": func() benchCache {
+			c, err := New(context.Background())
+			if err != nil {
+				b.Fatal(err)
+			}
+			return c
+		},
+	}
+}
+
+func BenchmarkCompareGet(b *testing.B) {
+	s, objs := createObjects()
+	for _, name := range []string{"baseline", "sharded"} {
+		c := benchCaches(b)[name]()
+		for i := 0; i < amountOfRuns; i++ {
+			c.Put(s[i], objs[i])
+		}
+		b.Run(name, func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				c.Get(s[i%amountOfRuns])
+			}
+		})
+		b.Run(name+"/parallel", func(b *testing.B) {
+			b.RunParallel(func(pb *testing.PB) {
+				i := 0
+				for pb.Next() {
+					c.Get(s[i%amountOfRuns])
+					i++
+				}
+			})
+		})
+	}
+}
+
+func BenchmarkComparePut(b *testing.B) {
+	s, objs := createObjects()
+	for _, name := range []string{"baseline", "sharded"} {
+		c := benchCaches(b)[name]()
+		b.Run(name, func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				c.Put(s[i%amountOfRuns], objs[i%amountOfRuns])
+			}
+		})
+		b.Run(name+"/parallel", func(b *testing.B) {
+			b.RunParallel(func(pb *testing.PB) {
+				i := 0
+				for pb.Next() {
+					c.Put(s[i%amountOfRuns], objs[i%amountOfRuns])
+					i++
+				}
+			})
+		})
+	}
+}
+
+func BenchmarkCacheGetParallel(b *testing.B) {
+	c, err := New(context.Background())
+	if err != nil {
+		b.Fatal(err)
+	}
+	for i := 0; i < amountOfRuns; i++ {
+		c.Put(strconv.Itoa(i), i)
+	}
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		i := 0
+		for pb.Next() {
+			c.Get(strconv.Itoa(i % amountOfRuns))
+			i++
+		}
+	})
+}
+
+// The rest of the operations the README gives figures for
+func BenchmarkOperations(b *testing.B) {
+	c, s, objs, err := cacheCreateWithObjects()
+	if err != nil {
+		b.Fatal(err)
+	}
+	// Items with a TTL make every read check the clock
+	b.Run("GetTTL", func(b *testing.B) {
+		for i := 0; i < amountOfRuns; i++ {
+			c.PutTTL(s[i], objs[i], time.Hour)
+		}
+		b.ResetTimer()
+		for i := 0; i < b.N; i++ {
+			c.Get(s[i%amountOfRuns])
+		}
+	})
+	b.Run("PutSafe", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			c.PutSafe(s[i%amountOfRuns], objs[i%amountOfRuns])
+		}
+	})
+	b.Run("PutCrypt", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			c.PutCrypt(s[i%amountOfRuns], s[i%amountOfRuns])
+		}
+	})
+	b.Run("GetCrypt", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			c.GetCrypt(s[i%amountOfRuns])
+		}
+	})
+	// Per item, saving and loading a cache of 100k items
+	c.Clear()
+	for i := 0; i < 100000; i++ {
+		c.Put(strconv.Itoa(i), objs[0])
+	}
+	var snap bytes.Buffer
+	b.Run("Save", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			snap.Reset()
+			if err := c.WriteSnapshot(&snap); err != nil {
+				b.Fatal(err)
+			}
+		}
+		b.ReportMetric(float64(b.Elapsed().Nanoseconds())/float64(b.N*c.Count()), "ns/item")
+	})
+	b.Run("Load", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			if err := c.ReadSnapshot(bytes.NewReader(snap.Bytes())); err != nil {
+				b.Fatal(err)
+			}
+		}
+		b.ReportMetric(float64(b.Elapsed().Nanoseconds())/float64(b.N*c.Count()), "ns/item")
+	})
+}
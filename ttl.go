@@ -0,0 +1,171 @@
+package godistcache
+
+import (
+	"math"
+	"time"
+)
+
+const (
+	// Use the cache's default time to live
+	DefaultExpiration time.Duration = 0
+	// Never expire, also returned by TTL for items that never expire
+	NoExpiration time.Duration = -1
+)
+
+// The expiration timestamp of items that never expire
+const noExpiry int64 = math.MaxInt64
+
+// Converts a delay in seconds into a time to live, delays too long for a time.Duration never expire
+// exp -> The delay in seconds, 0 uses the default expiration
+func secondsTTL(exp int64) time.Duration {
+	if exp > math.MaxInt64/int64(time.Second) {
+		return NoExpiration
+	}
+	// Long past is still in the past
+	if exp < math.MinInt64/int64(time.Second) {
+		return math.MinInt64
+	}
+	return time.Duration(exp) * time.Second
+}
+
+// Replaces DefaultExpiration with the store's time to live
+// ttl -> The time to live to resolve
+func (s *store[K, V]) resolve(ttl time.Duration) time.Duration {
+	if ttl == DefaultExpiration {
+		return s.defTTL
+	}
+	return ttl
+}
+
+// Returns the expiration timestamp for an item stored now, noExpiry if it never expires
+// ttl -> The time to live, DefaultExpiration uses the store's and NoExpiration never expires
+func (s *store[K, V]) expiry(ttl time.Duration) int64 {
+	ttl = s.resolve(ttl)
+	if ttl == NoExpiration {
+		return noExpiry
+	}
+	now := s.now()
+	if ttl > 0 && now > noExpiry-int64(ttl) {
+		return noExpiry
+	}
+	return now + int64(ttl)
+}
+
+// Returns the timestamp an item that turns stale at soft gets deleted at
+// soft -> The timestamp the item turns stale
+func (s *store[K, V]) hardExpiry(soft int64) int64 {
+	if soft > noExpiry-s.grace {
+		return noExpiry
+	}
+	return soft + s.grace
+}
+
+// Returns the current time of the store's clock in Unix UTC nanoseconds
+func (s *store[K, V]) now() int64 {
+	return s.clock.Now().UnixNano()
+}
+
+// Tells you whether or not an expiration timestamp has passed
+// e -> The expiration timestamp in Unix UTC nanoseconds
+func (s *store[K, V]) isExpired(e int64) bool {
+	return e < s.now()
+}
+
+// Splits an expiration timestamp into the fields saved in files
+// The timestamp in seconds is the field files saved before nanosecond precision have, it's still filled in for tools that read it
+// soft -> The expiration timestamp in Unix UTC nanoseconds
+func encodeExpiry(soft int64) (e int64, n int64, never bool) {
+	if soft == noExpiry {
+		return math.MaxInt64, 0, true
+	}
+	return soft / int64(time.Second), soft, false
+}
+
+// Joins the fields saved in files into an expiration timestamp
+// Files saved before nanosecond precision only have e, their 1000 year "never expire" becomes noExpiry
+// e -> The expiration timestamp in Unix UTC seconds
+// n -> The expiration timestamp in Unix UTC nanoseconds, 0 in older files
+// never -> The item never expires
+func decodeExpiry(e, n int64, never bool) int64 {
+	switch {
+	case never:
+		return noExpiry
+	case n != 0:
+		return n
+	case e > noExpiry/int64(time.Second):
+		return noExpiry
+	}
+	return e * int64(time.Second)
+}
+
+// Joins the sliding expiration fields saved in files
+// s -> The sliding expiration in seconds
+// d -> The sliding expiration, 0 in older files
+func decodeSlide(s int64, d time.Duration) time.Duration {
+	if d != 0 {
+		return d
+	}
+	return time.Duration(s) * time.Second
+}
+
+// Move the expiration of an item to ttl from now
+// Returns false if the item doesn't exist or is past its grace period
+// key -> The key to lookup in the store
+// ttl -> The time to live, DefaultExpiration uses the store's and NoExpiration never expires
+// read -> The move comes from a read of a sliding item, which keeps its sliding expiration and isn't logged or uploaded as a change
+func (s *store[K, V]) touch(key K, ttl time.Duration, read bool) bool {
+	sh := s.shardFor(key)
+	sh.m.Lock()
+	defer sh.m.Unlock()
+	v, ok := sh.items[key]
+	if !ok || s.isExpired(v.e) {
+		return false
+	}
+	v.soft = s.expiry(ttl)
+	v.e = s.hardExpiry(v.soft)
+	if !read && v.slide > 0 {
+		v.slide = max(s.resolve(ttl), 0)
+	}
+	sh.items[key] = v
+	// Logging every read would turn reads into writes, after a crash or from a delta the item only loses how far reads moved it
+	if !read {
+		s.wal.logExpiry(key, v.soft, v.slide)
+		sh.touched(key)
+	}
+	return true
+}
+
+// Returns how long until an item expires, NoExpiration if it never does
+// Returns false if the item doesn't exist or has expired
+// key -> The key to lookup in the store
+func (s *store[K, V]) ttl(key K) (time.Duration, bool) {
+	sh := s.shardFor(key)
+	sh.m.RLock()
+	v, ok := sh.items[key]
+	sh.m.RUnlock()
+	if !ok || s.isExpired(v.soft) {
+		return 0, false
+	}
+	if v.soft == noExpiry {
+		return NoExpiration, true
+	}
+	return time.Duration(v.soft - s.now()), true
+}
+
+// Remove the expiration of an item so it's kept until it's deleted or evicted
+// Returns false if the item doesn't exist or has expired
+// key -> The key to lookup in the store
+func (s *store[K, V]) persist(key K) bool {
+	sh := s.shardFor(key)
+	sh.m.Lock()
+	defer sh.m.Unlock()
+	v, ok := sh.items[key]
+	if !ok || s.isExpired(v.soft) {
+		return false
+	}
+	v.soft, v.e, v.slide = noExpiry, noExpiry, 0
+	sh.items[key] = v
+	s.wal.logExpiry(key, noExpiry, 0)
+	sh.touched(key)
+	return true
+}
@@ -0,0 +1,177 @@
+package godistcache
+
+import (
+	"context"
+	"encoding/gob"
+	"io"
+	"testing"
+	"time"
+
+	"github.com/mbarreca/godistcache/godistcachetest"
+)
+
+func TestTouchTTLPersist(t *testing.T) {
+	c, err := New(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	c.PutExp("a", 1, 60)
+	if ttl, ok := c.TTL("a"); !ok || ttl > time.Minute || ttl < 59*time.Second {
+		t.Fatalf("Unexpected TTL: %v", ttl)
+	}
+	if !c.Touch("a", time.Hour) {
+		t.Fatalf("Touch didn't find the item")
+	}
+	if ttl, _ := c.TTL("a"); ttl < 59*time.Minute {
+		t.Fatalf("Touch didn't move the expiration: %v", ttl)
+	}
+	if !c.Persist("a") {
+		t.Fatalf("Persist didn't find the item")
+	}
+	if ttl, ok := c.TTL("a"); !ok || ttl != NoExpiration {
+		t.Fatalf("Persisted item still expires: %v", ttl)
+	}
+	if v, e := c.Get("a"); !e || v != 1 {
+		t.Fatalf("Persisted item wasn't returned")
+	}
+	// Missing and expired items can't be changed
+	c.PutExp("b", 1, -1)
+	if _, ok := c.TTL("b"); ok || c.Touch("b", time.Hour) || c.Persist("b") || c.Touch("c", time.Hour) {
+		t.Fatalf("Expired or missing item was changed")
+	}
+}
+
+func TestSlidingExpiration(t *testing.T) {
+	clock := godistcachetest.NewClock(time.Now())
+	c := newTestTyped[string, int](t, WithClock(clock))
+	c.PutSliding("a", 1, 2)
+	c.PutExp("b", 1, 2)
+	clock.Advance(1100 * time.Millisecond)
+	c.Get("a")
+	c.Get("b")
+	// Reading "a" moved its expiration, "b" kept its own
+	ttlA, _ := c.TTL("a")
+	ttlB, _ := c.TTL("b")
+	if ttlA != 2*time.Second || ttlB != 900*time.Millisecond {
+		t.Fatalf("TTL of the sliding item: %v, TTL of the fixed item: %v", ttlA, ttlB)
+	}
+}
+
+func TestSlidingExpirationOption(t *testing.T) {
+	clock := godistcachetest.NewClock(time.Now())
+	c := newTestTyped[string, int](t, WithSlidingExpiration(), WithClock(clock))
+	c.PutExp("a", 1, 2)
+	clock.Advance(1100 * time.Millisecond)
+	c.Get("a")
+	if ttl, _ := c.TTL("a"); ttl != 2*time.Second {
+		t.Fatalf("Read didn't slide the expiration: %v", ttl)
+	}
+}
+
+func TestSubSecondExpiration(t *testing.T) {
+	clock := godistcachetest.NewClock(time.Now())
+	c := newTestTyped[string, int](t, WithClock(clock))
+	c.PutTTL("a", 1, 100*time.Millisecond)
+	c.PutUntil("b", 1, clock.Now().Add(100*time.Millisecond))
+	c.PutUntil("c", 1, time.Time{})
+	clock.Advance(100 * time.Millisecond)
+	if _, e := c.Get("a"); !e {
+		t.Fatalf("Item expired before its TTL")
+	}
+	clock.Advance(time.Nanosecond)
+	if _, e := c.Get("a"); e {
+		t.Fatalf("PutTTL item didn't expire")
+	}
+	if _, e := c.Get("b"); e {
+		t.Fatalf("PutUntil item didn't expire")
+	}
+	if ttl, ok := c.TTL("c"); !ok || ttl != NoExpiration {
+		t.Fatalf("Zero time item expires: %v", ttl)
+	}
+}
+
+func TestExpirationSaveLoad(t *testing.T) {
+	c := newTestTyped[string, int](t)
+	c.PutTTL("a", 1, time.Hour+250*time.Millisecond)
+	c.Put("b", 1)
+	fpwd := t.TempDir() + "/ttltest"
+	if err := c.SaveToBinaryFile(fpwd); err != nil {
+		t.Fatal(err)
+	}
+	c2 := newTestTyped[string, int](t)
+	if err := c2.LoadFromBinary(fpwd); err != nil {
+		t.Fatal(err)
+	}
+	ttlA, _ := c.TTL("a")
+	ttlA2, _ := c2.TTL("a")
+	if d := ttlA - ttlA2; d < 0 || d > 100*time.Millisecond {
+		t.Fatalf("Expiration lost precision: %v saved, %v loaded", ttlA, ttlA2)
+	}
+	if ttl, _ := c2.TTL("b"); ttl != NoExpiration {
+		t.Fatalf("Item without expiration expires after loading: %v", ttl)
+	}
+}
+
+func TestExpirationLoadOldFormat(t *testing.T) {
+	// Files saved before nanosecond precision only have E and S, used a 1000 year expiration for "never" and had no header
+	type oldItem struct {
+		V int
+		E int64
+		S int64
+	}
+	now := time.Now().Unix()
+	fpwd := t.TempDir() + "/oldtest"
+	old := map[string]oldItem{"a": {V: 1, E: now + 60, S: 60}, "b": {V: 2, E: now + 1000*365*24*60*60}}
+	if err := writeBinaryFile(fpwd, 0o644, 0, func(w io.Writer) error { return gob.NewEncoder(w).Encode(old) }); err != nil {
+		t.Fatal(err)
+	}
+	c := newTestTyped[string, int](t)
+	if err := c.LoadFromBinary(fpwd); err != nil {
+		t.Fatal(err)
+	}
+	if ttl, ok := c.TTL("a"); !ok || ttl > time.Minute || ttl < 58*time.Second {
+		t.Fatalf("Unexpected TTL of an old item: %v", ttl)
+	}
+	if ttl, ok := c.TTL("b"); !ok || ttl != NoExpiration {
+		t.Fatalf("Old \"never expire\" item expires: %v", ttl)
+	}
+	c.Get("a")
+	if ttl, _ := c.TTL("a"); ttl < 59*time.Second {
+		t.Fatalf("Old sliding item didn't slide: %v", ttl)
+	}
+}
+
+func TestDefaultTTL(t *testing.T) {
+	clock := godistcachetest.NewClock(time.Now())
+	c := newTestTyped[string, int](t, WithDefaultTTL(time.Minute), WithClock(clock))
+	c.Put("a", 1)
+	c.PutTTL("b", 1, NoExpiration)
+	if ttl, _ := c.TTL("a"); ttl != time.Minute {
+		t.Fatalf("Put didn't use the default TTL: %v", ttl)
+	}
+	clock.Advance(time.Minute + time.Nanosecond)
+	_, a := c.Get("a")
+	_, b := c.Get("b")
+	if a || !b {
+		t.Fatalf("Default TTL wasn't applied to the right items")
+	}
+}
+
+func TestLongExpiration(t *testing.T) {
+	c, err := New(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The offset old versions used to never expire is longer than a time.Duration
+	c.PutExp("long", 1, 1000*365*24*3600)
+	c.PutSliding("sliding", 1, 1000*365*24*3600)
+	for _, key := range []string{"long", "sliding"} {
+		if ttl, ok := c.TTL(key); !ok || ttl != NoExpiration {
+			t.Fatalf("%v expired right away: %v, %v", key, ttl, ok)
+		}
+	}
+	c.PutExp("past", 1, -1000*365*24*3600)
+	if _, ok := c.Get("past"); ok {
+		t.Fatalf("Item far in the past didn't expire")
+	}
+}
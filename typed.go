@@ -0,0 +1,362 @@
+package godistcache
+
+import (
+	"context"
+	"errors"
+	"io"
+	"time"
+)
+
+// This is the type-safe cache object, values come back as V without a type assertion
+type TypedCache[K comparable, V any] struct {
+	s    *store[K, V]   // Where the items are stored
+	p    *persister     // Saves the cache to the backend
+	snap snapshotConfig // How snapshots are written
+}
+
+// This object is internally what exists in each item of a TypedCache file
+type TypedCacheItem[V any] struct {
+	V     V             // The item to store
+	E     int64         // Expiration timestamp in Unix UTC seconds, the only one in files saved before N existed
+	S     int64         // Sliding expiration in seconds, 0 is a fixed expiration, the only one in files saved before D existed
+	N     int64         // Expiration timestamp in Unix UTC nanoseconds, 0 in files saved before it existed
+	D     time.Duration // Sliding expiration, 0 is a fixed expiration or a file saved before it existed
+	Never bool          // The item never expires
+}
+
+// Creates a new type-safe cache
+// ctx -> The context you want to provide for purposes of telemetry
+// opts -> Optional settings such as WithDefaultTTL, WithS3 or FromEnv
+func NewTyped[K comparable, V any](ctx context.Context, opts ...Option) (*TypedCache[K, V], error) {
+	o := newOptions(opts)
+	if o.err != nil {
+		return nil, o.err
+	}
+	// Setup the backend
+	p, err := newPersister(ctx, o)
+	if err != nil {
+		return nil, err
+	}
+	s := newStore[K, V](o.defaultTTL, o)
+	if err := attachWAL(s, p, o); err != nil {
+		s.stopJanitor()
+		return nil, err
+	}
+	c := &TypedCache[K, V]{s: s, p: p, snap: newSnapshotConfig(o)}
+	attachDeltas(s, p, wrapTypedItem[V], c.snap)
+	return c, nil
+}
+
+// Creates a new type-safe cache from a file in the backend
+// cacheKey -> The key of the file in the backend - DO NOT include the .godistcache extension
+// ctx -> The context you want to provide for purposes of telemetry
+// opts -> Optional settings, the backend has to be set up with WithBackend, WithS3 or FromEnv
+func NewTypedFromBackend[K comparable, V any](cacheKey string, ctx context.Context, opts ...Option) (*TypedCache[K, V], error) {
+	c, err := NewTyped[K, V](ctx, opts...)
+	if err != nil {
+		return nil, err
+	}
+	// Download the file and load the entries in from the cache, with the deltas uploaded since
+	err = c.p.load(ctx, cacheKey, func(r io.Reader) error {
+		return c.load(r, deltaStep[K, V, TypedCacheItem[V]](ctx, c.p, cacheKey, unwrapTypedItem[V], nil), replayStep(c.s))
+	})
+	if err != nil {
+		// Nothing was started in the background yet, stop the janitor and the log without saving
+		c.s.stopJanitor()
+		return nil, errors.Join(err, c.s.wal.close())
+	}
+	return c, nil
+}
+
+// Creates a new type-safe cache from a file in S3, the same as NewTypedFromBackend
+// Deprecated: Use NewTypedFromBackend
+func NewTypedFromS3[K comparable, V any](cacheKey string, ctx context.Context, opts ...Option) (*TypedCache[K, V], error) {
+	return NewTypedFromBackend[K, V](cacheKey, ctx, opts...)
+}
+
+// Start saving the cache to the backend every interval in the background, to the key set with WithObjectKey
+// A run never starts before the previous one is done, failures are logged and passed to WithErrorHandler
+// Returns ErrBackendUnavailable if there is no backend and ErrPersistenceStarted if it's already running
+// ctx -> Persistence stops once it's done
+// interval -> How long to wait between runs
+func (c *TypedCache[K, V]) StartPersistence(ctx context.Context, interval time.Duration) error {
+	return c.p.start(ctx, interval, c.WriteSnapshot)
+}
+
+// Stop persistence and the janitor, then save the cache to the backend one last time if there is one and close the write-ahead log
+// The cache can still be used afterwards, but writes are no longer logged
+// A run in progress is canceled, Close waits for it and for the final save, so nothing reads the cache once it returns
+// ctx -> Cancels the final save, which then returns the context's error
+func (c *TypedCache[K, V]) Close(ctx context.Context) error {
+	c.s.stopJanitor()
+	return errors.Join(c.p.close(ctx, c.WriteSnapshot), c.s.wal.close())
+}
+
+// Add an item to the cache
+// key -> The key to lookup in the cache
+// value -> The value to store in the cache
+func (c *TypedCache[K, V]) Put(key K, value V) {
+	c.s.put(key, value, DefaultExpiration)
+}
+
+// Add an item with a manual expiration offset (in seconds)
+// key -> The key to lookup in the cache
+// value -> The value to store in the cache
+// exp -> The expiration delay from now, in seconds
+func (c *TypedCache[K, V]) PutExp(key K, value V, exp int64) {
+	c.s.put(key, value, secondsTTL(exp))
+}
+
+// Add an item with a time to live, with sub-second precision
+// key -> The key to lookup in the cache
+// value -> The value to store in the cache
+// ttl -> The time to live, DefaultExpiration uses the cache's and NoExpiration never expires
+func (c *TypedCache[K, V]) PutTTL(key K, value V, ttl time.Duration) {
+	c.s.put(key, value, ttl)
+}
+
+// Add an item that expires at the given time
+// key -> The key to lookup in the cache
+// value -> The value to store in the cache
+// t -> When the item expires, the zero time never expires
+func (c *TypedCache[K, V]) PutUntil(key K, value V, t time.Time) {
+	soft := noExpiry
+	if !t.IsZero() {
+		soft = t.UnixNano()
+	}
+	c.s.putAt(key, value, soft, 0)
+}
+
+// Add an item with a sliding expiration, every read moves its expiration exp seconds from now
+// key -> The key to lookup in the cache
+// value -> The value to store in the cache
+// exp -> The expiration delay from now and from every read, in seconds, 0 uses the default expiration
+func (c *TypedCache[K, V]) PutSliding(key K, value V, exp int64) {
+	c.s.putSliding(key, value, secondsTTL(exp))
+}
+
+// Attempt to get an item from the cache. Will return the item and a bool to indicate success
+// key -> The key to lookup in the cache
+func (c *TypedCache[K, V]) Get(key K) (V, bool) {
+	return c.s.get(key)
+}
+
+// Get an item from the cache, or load it on a miss and cache it with the TTL the loader returns
+// Concurrent misses for the same key share one loader call
+// ctx -> Passed to the loader, callers waiting on another caller's load stop when their own context is done
+// key -> The key to lookup in the cache
+// loader -> Loads the value on a miss, returns the value, its TTL (DefaultExpiration uses the cache's) and an error
+func (c *TypedCache[K, V]) GetOrLoad(ctx context.Context, key K, loader Loader[V]) (V, error) {
+	return c.s.getOrLoad(ctx, key, loader)
+}
+
+// Move the expiration of an item without rewriting its value, returns false if it doesn't exist
+// Sliding items keep sliding by the new TTL
+// key -> The key to lookup in the cache
+// ttl -> The new time to live from now, DefaultExpiration uses the cache's and NoExpiration never expires
+func (c *TypedCache[K, V]) Touch(key K, ttl time.Duration) bool {
+	return c.s.touch(key, ttl, false)
+}
+
+// Returns how long until an item expires, NoExpiration if it never does, and false if it doesn't exist
+// key -> The key to lookup in the cache
+func (c *TypedCache[K, V]) TTL(key K) (time.Duration, bool) {
+	return c.s.ttl(key)
+}
+
+// Remove the expiration of an item so it's kept until deleted or evicted, returns false if it doesn't exist
+// key -> The key to lookup in the cache
+func (c *TypedCache[K, V]) Persist(key K) bool {
+	return c.s.persist(key)
+}
+
+// Delete an item from the cache
+// key -> The key to lookup in the cache
+func (c *TypedCache[K, V]) Delete(key K) {
+	c.s.delete(key)
+}
+
+// Returns the amount of items in the cache
+func (c *TypedCache[K, V]) Count() int {
+	return c.s.count()
+}
+
+// Returns the estimated size of all items in bytes, only tracked when WithMaxBytes or WithSizer is set
+func (c *TypedCache[K, V]) Bytes() int64 {
+	return c.s.size()
+}
+
+// Returns the amount of items evicted because the cache reached its maximum amount of entries or bytes
+func (c *TypedCache[K, V]) Evictions() uint64 {
+	return c.s.evictionCount()
+}
+
+// Tells you whether or not the item corresponding to the key exists
+// key -> The key to lookup in the cache
+func (c *TypedCache[K, V]) Exists(key K) bool {
+	return c.s.exists(key)
+}
+
+// Register a callback for every item that leaves the cache, replaces the previous one
+// The callback runs outside the cache lock so it can safely call back into the cache
+// f -> Receives the key, the value and why it left
+func (c *TypedCache[K, V]) OnEvicted(f func(key K, value V, reason EvictReason)) {
+	c.s.setHooks(func(h *hooks[K, V]) { h.evicted = f })
+}
+
+// Register a callback for every new key stored in the cache, replaces the previous one
+// The callback runs outside the cache lock so it can safely call back into the cache
+// f -> Receives the key and the value
+func (c *TypedCache[K, V]) OnInserted(f func(key K, value V)) {
+	c.s.setHooks(func(h *hooks[K, V]) { h.inserted = f })
+}
+
+// Register a callback for every existing key that gets a new value, replaces the previous one
+// The callback runs outside the cache lock so it can safely call back into the cache
+// f -> Receives the key, the previous value and the new value
+func (c *TypedCache[K, V]) OnUpdated(f func(key K, old, value V)) {
+	c.s.setHooks(func(h *hooks[K, V]) { h.updated = f })
+}
+
+// Register the function that refreshes stale items in the background, replaces the previous one
+// Used with WithStaleWhileRevalidate and WithRefreshAhead
+// f -> Reloads the value of a key, returns the value, its TTL (DefaultExpiration uses the cache's) and an error
+func (c *TypedCache[K, V]) SetRefresher(f Refresher[K, V]) {
+	c.s.setHooks(func(h *hooks[K, V]) { h.refresh = f })
+}
+
+// Check every item and delete the expired ones, returns the amount deleted
+func (c *TypedCache[K, V]) DeleteExpired() int {
+	return c.s.deleteExpired()
+}
+
+// Stop the background janitor started with WithJanitor, safe to call more than once
+func (c *TypedCache[K, V]) Stop() {
+	c.s.stopJanitor()
+}
+
+// DANGEROUS - This will clear the cache
+func (c *TypedCache[K, V]) Clear() {
+	c.s.clear()
+}
+
+// This will convert the cache to a binary and save it to a file
+// Concrete types don't need to be registered with Gob, only interfaces stored inside V do
+// filePathName -> The path with the filename - DO NOT add the extension .godistcache
+func (c *TypedCache[K, V]) SaveToBinaryFile(filePathName string) error {
+	return checkpoint(c.p.log, func() error {
+		return writeBinaryFile(filePathName, c.snap.fileMode, c.snap.generations, c.WriteSnapshot)
+	})
+}
+
+// Encode the cache as a .godistcache file and stream it to w, nothing touches the disk
+// w -> Where the snapshot is written
+func (c *TypedCache[K, V]) WriteSnapshot(w io.Writer) error {
+	m := snapshot(c.s, wrapTypedItem[V])
+	return writeSnapshot(w, c.snap.header(len(m)), m)
+}
+
+// Converts a value of the cache into the item saved in snapshots
+// v -> The value
+// soft -> The expiration in Unix UTC nanoseconds
+// slide -> The sliding expiration
+func wrapTypedItem[V any](v V, soft int64, slide time.Duration) TypedCacheItem[V] {
+	e, n, never := encodeExpiry(soft)
+	return TypedCacheItem[V]{V: v, E: e, S: int64(slide / time.Second), N: n, D: slide, Never: never}
+}
+
+// Returns the value, expiration and sliding expiration of an item saved in a snapshot
+func unwrapTypedItem[V any](i TypedCacheItem[V]) (V, int64, time.Duration) {
+	return i.V, decodeExpiry(i.E, i.N, i.Never), decodeSlide(i.S, i.D)
+}
+
+// This will load a .godistcache file saved by a TypedCache with the same K and V into your cache
+// filePathName -> The path with the filename - DO NOT add the extension .godistcache
+func (c *TypedCache[K, V]) LoadFromBinary(filePathName string) error {
+	return loadBinaryFile(filePathName, c.replayLoad)
+}
+
+// Decode a .godistcache file streamed from r and replace the cache with its items
+// Nothing is replaced unless the whole file passes its checksums
+// r -> Where the snapshot is read from
+func (c *TypedCache[K, V]) ReadSnapshot(r io.Reader) error {
+	return c.load(r)
+}
+
+// Decode a .godistcache file and replay the write-ahead log on top of it, then replace the cache with the result
+// r -> Where the snapshot is read from
+func (c *TypedCache[K, V]) replayLoad(r io.Reader) error {
+	return c.load(r, replayStep(c.s))
+}
+
+// Stop loads from replaying the write-ahead log left by the previous run on top of their snapshot
+// The cache already starts from the log when it's created, so there is nothing else to replay
+// Deprecated: Not needed anymore, the log is replayed when the cache is created
+func (c *TypedCache[K, V]) ReplayWAL() error {
+	return replayWAL(c.s)
+}
+
+// Decode a .godistcache file and replace the cache with its items
+// r -> Where the snapshot is read from
+// steps -> Change the decoded items before they replace the cache
+func (c *TypedCache[K, V]) load(r io.Reader, steps ...loadStep[K, V]) error {
+	m := make(map[K]TypedCacheItem[V])
+	h, err := readSnapshot(r, &m)
+	if err != nil {
+		return err
+	}
+	return restoreWith(c.s, h, m, unwrapTypedItem[V], steps...)
+}
+
+// Load a .godistcache file into the cache without dropping what it already holds
+// Keys the cache already has keep their item unless MergeWith or MergeResolver says otherwise
+// filePathName -> The path with the filename - DO NOT add the extension .godistcache
+// opts -> MergeWith or MergeResolver to settle conflicts, MergeSkipExpired to leave out expired items
+func (c *TypedCache[K, V]) MergeFromBinary(filePathName string, opts ...MergeOption) error {
+	return loadBinaryFile(filePathName, func(r io.Reader) error {
+		return c.merge(r, opts)
+	})
+}
+
+// Download a cache file from the backend, with the deltas uploaded since, and load it into the cache without dropping what it already holds
+// ctx -> Cancels the download
+// cacheKey -> The key of the file in the backend - DO NOT include the .godistcache extension
+// opts -> MergeWith or MergeResolver to settle conflicts, MergeSkipExpired to leave out expired items
+func (c *TypedCache[K, V]) MergeFromBackend(ctx context.Context, cacheKey string, opts ...MergeOption) error {
+	return c.p.load(ctx, cacheKey, func(r io.Reader) error {
+		return c.merge(r, opts, deltaStep[K, V, TypedCacheItem[V]](ctx, c.p, cacheKey, unwrapTypedItem[V], nil))
+	})
+}
+
+// Merge a cache file from S3 into the cache, the same as MergeFromBackend
+func (c *TypedCache[K, V]) MergeFromS3(ctx context.Context, cacheKey string, opts ...MergeOption) error {
+	return c.MergeFromBackend(ctx, cacheKey, opts...)
+}
+
+// Decode a .godistcache file and merge its items into the cache
+// r -> Where the snapshot is read from
+// opts -> How conflicts are settled and which items are left out
+// steps -> Change the decoded items before they are merged
+func (c *TypedCache[K, V]) merge(r io.Reader, opts []MergeOption, steps ...loadStep[K, V]) error {
+	m := make(map[K]TypedCacheItem[V])
+	h, err := readSnapshot(r, &m)
+	if err != nil {
+		return err
+	}
+	return mergeWith(c.s, h, m, unwrapTypedItem[V], opts, steps...)
+}
+
+// Write every item as a line of JSON, {"key": ..., "value": ..., "expires_at": ...}, readable by jq and other tools
+// w -> Where the lines are written
+// opts -> JSONSkipExpired or JSONKeyPrefix to leave items out
+func (c *TypedCache[K, V]) ExportJSON(w io.Writer, opts ...JSONOption) error {
+	return exportJSON(c.s, w, nil, opts)
+}
+
+// Load lines of JSON written by ExportJSON, replacing the cache unless JSONMerge is passed
+// Nothing is changed unless every line could be read
+// r -> Where the lines are read from
+// opts -> JSONMerge to add to the cache, JSONSkipExpired or JSONKeyPrefix to leave items out
+func (c *TypedCache[K, V]) ImportJSON(r io.Reader, opts ...JSONOption) error {
+	return importJSON(c.s, r, decodeJSON[V], opts)
+}
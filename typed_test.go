@@ -0,0 +1,105 @@
+package godistcache
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"testing"
+)
+
+// Intentionally never registered with Gob
+type typedObject struct {
+	Name string
+	Tags []string
+}
+
+// Creates a TypedCache for a test, failing it if the cache can't be created
+func newTestTyped[K comparable, V any](tb testing.TB, opts ...Option) *TypedCache[K, V] {
+	c, err := NewTyped[K, V](context.Background(), opts...)
+	if err != nil {
+		tb.Fatal(err)
+	}
+	return c
+}
+
+func TestTypedCache(t *testing.T) {
+	c := newTestTyped[int, []string](t)
+	for i := 0; i < amountOfRuns; i++ {
+		c.Put(i, []string{"a", "b"})
+	}
+	if c.Count() != amountOfRuns {
+		t.Fatalf("Amount of Cache Items != Amount Counted")
+	}
+	for i := 0; i < amountOfRuns; i++ {
+		v, e := c.Get(i)
+		if !e || len(v) != 2 || v[1] != "b" {
+			t.Fatalf("Failed reading Get on Index: %v", i)
+		}
+	}
+	c.Delete(0)
+	if c.Exists(0) {
+		t.Fatalf("Delete doesn't work")
+	}
+	// Expired items shouldn't be returned
+	c.PutExp(1, []string{"c"}, -1)
+	if _, e := c.Get(1); e {
+		t.Fatalf("Expired item was returned")
+	}
+	c.Clear()
+	if c.Count() != 0 {
+		t.Fatalf("Clear cache doesn't work")
+	}
+}
+
+func TestTypedCacheSaveLoad(t *testing.T) {
+	c := newTestTyped[string, typedObject](t)
+	s, _ := createObjects()
+	for i := 0; i < amountOfRuns; i++ {
+		c.Put(s[i], typedObject{Name: s[i], Tags: []string{s[i]}})
+	}
+	pwd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	fpwd := pwd + "/typedtest"
+	if err := c.SaveToBinaryFile(fpwd); err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(fpwd + ".godistcache")
+
+	c2 := newTestTyped[string, typedObject](t)
+	if err := c2.LoadFromBinary(fpwd); err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < amountOfRuns; i++ {
+		v, e := c2.Get(s[i])
+		if !e || v.Name != s[i] || v.Tags[0] != s[i] {
+			t.Fatalf("Failed reading loaded item on Index: %v", i)
+		}
+	}
+}
+
+func TestTypedCacheSnapshot(t *testing.T) {
+	c := newTestTyped[string, typedObject](t)
+	s, _ := createObjects()
+	for i := 0; i < amountOfRuns; i++ {
+		c.Put(s[i], typedObject{Name: s[i], Tags: []string{s[i]}})
+	}
+	var buf bytes.Buffer
+	if err := c.WriteSnapshot(&buf); err != nil {
+		t.Fatal(err)
+	}
+	c2 := newTestTyped[string, typedObject](t)
+	c2.Put("stale", typedObject{})
+	if err := c2.ReadSnapshot(&buf); err != nil {
+		t.Fatal(err)
+	}
+	if c2.Count() != amountOfRuns || c2.Exists("stale") {
+		t.Fatalf("Reading a snapshot didn't replace the cache: %v items", c2.Count())
+	}
+	for i := 0; i < amountOfRuns; i++ {
+		if v, e := c2.Get(s[i]); !e || v.Name != s[i] {
+			t.Fatalf("Failed reading loaded item on Index: %v", i)
+		}
+	}
+}
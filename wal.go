@@ -0,0 +1,545 @@
+package godistcache
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"encoding/gob"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// How often the write-ahead log is flushed to disk
+type WALSync int
+
+const (
+	WALSyncEverySecond WALSync = iota // fsync once a second, a crash loses at most a second of writes, the default
+	WALSyncAlways                     // fsync after every write, nothing is lost but every write waits for the disk
+	WALSyncNever                      // Leave flushing to the operating system
+)
+
+// The size a segment grows to before the log moves on to the next one, unless WithWALSegmentSize says otherwise
+const defaultWALSegmentSize = 64 << 20
+
+// The extension of every segment of the log
+const walExtension = ".wal"
+
+// What a record of the log does
+type walOp uint8
+
+const (
+	walPut    walOp = iota + 1 // Stores Value under Key
+	walDelete                  // Removes Key
+	walClear                   // Removes every key
+	walExpiry                  // Changes the expiration of Key
+)
+
+// One write recorded in the log
+type walRecord[K comparable, V any] struct {
+	Op    walOp
+	Key   K
+	Value V
+	Soft  int64         // The expiration timestamp in Unix UTC nanoseconds
+	Slide time.Duration // The sliding expiration
+}
+
+// An append-only log of every write since the last snapshot, split into numbered segment files
+// Every record is framed with its length and CRC32C, so a write torn by a crash is dropped instead of loaded
+type wal[K comparable, V any] struct {
+	dir         string      // Where the segments are
+	policy      WALSync     // When the segments are flushed to disk
+	segmentSize int64       // The size a segment grows to before the next one starts
+	logger      Logger      // Receives what couldn't be logged or replayed
+	onError     func(error) // Also receives what couldn't be logged, nil to only log it
+
+	mu      sync.Mutex
+	f       *os.File     // The segment being written, nil if it couldn't be created
+	closed  bool         // Close was called, nothing is logged anymore
+	seq     uint64       // The number of the segment being written
+	size    int64        // How much was written to it
+	buf     bytes.Buffer // Holds a record while it's framed
+	enc     *gob.Encoder // Encodes records into buf, one stream per segment
+	dirty   bool         // Something was written since the last fsync
+	pending []uint64     // The segments left by the previous run, replayed once
+	stop    chan struct{}
+	done    chan struct{}
+}
+
+// The log of a cache, it only logs and replays, rotate and compact make it a checkpointer for persistence
+type checkpointer interface {
+	rotate() (uint64, error)
+	compact(seq uint64) error
+}
+
+// Open the write-ahead log set up with WithWAL and attach it to the store and the persister
+// s -> The store whose writes are logged
+// p -> The persister whose snapshots compact the log
+// o -> The options the cache was created with
+func attachWAL[K comparable, V any](s *store[K, V], p *persister, o options) error {
+	if o.walDir == "" {
+		return nil
+	}
+	w := &wal[K, V]{dir: o.walDir, policy: o.walSync, segmentSize: o.walSegmentSize, logger: o.logger, onError: o.onError}
+	if w.segmentSize <= 0 {
+		w.segmentSize = defaultWALSegmentSize
+	}
+	if err := os.MkdirAll(w.dir, 0o755); err != nil {
+		return err
+	}
+	segments, err := w.segments()
+	if err != nil {
+		return err
+	}
+	w.pending = segments
+	if len(segments) > 0 {
+		w.seq = segments[len(segments)-1]
+		// Start from what the log holds, so a snapshot saved before any load still has the writes of the previous run
+		// The segments stay pending, a load replays them again on top of its snapshot
+		items := make(map[K]importedItem[V])
+		if err := w.replaySegments(segments, items); err != nil {
+			return err
+		}
+		restore(s, items, unwrapImported[V])
+	}
+	w.seq++
+	if err := w.openSegment(); err != nil {
+		return err
+	}
+	if w.policy == WALSyncEverySecond {
+		w.stop, w.done = make(chan struct{}), make(chan struct{})
+		go w.syncLoop(o.clock)
+	}
+	s.wal = w
+	p.log = w
+	return nil
+}
+
+// Returns the numbers of every segment in the directory, in order
+func (w *wal[K, V]) segments() ([]uint64, error) {
+	entries, err := os.ReadDir(w.dir)
+	if err != nil {
+		return nil, err
+	}
+	var seqs []uint64
+	for _, e := range entries {
+		name, ok := strings.CutSuffix(e.Name(), walExtension)
+		if !ok {
+			continue
+		}
+		if seq, err := strconv.ParseUint(name, 10, 64); err == nil {
+			seqs = append(seqs, seq)
+		}
+	}
+	slices.Sort(seqs)
+	return seqs, nil
+}
+
+// Returns the path of a segment
+// seq -> The number of the segment
+func (w *wal[K, V]) path(seq uint64) string {
+	return filepath.Join(w.dir, fmt.Sprintf("%020d%s", seq, walExtension))
+}
+
+// Create the segment numbered w.seq and start writing to it, must hold the lock
+func (w *wal[K, V]) openSegment() error {
+	f, err := os.OpenFile(w.path(w.seq), os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o644)
+	if err != nil {
+		return err
+	}
+	w.f, w.size, w.dirty = f, 0, false
+	w.buf.Reset()
+	w.enc = gob.NewEncoder(&w.buf)
+	return nil
+}
+
+// Record that a value was stored, must be called while the key's shard is locked so records keep the order of the writes
+// key -> The key that was stored
+// v -> The value
+// soft -> The expiration timestamp in Unix UTC nanoseconds
+// slide -> The sliding expiration
+func (w *wal[K, V]) logPut(key K, v V, soft int64, slide time.Duration) {
+	if w != nil {
+		w.append(walRecord[K, V]{Op: walPut, Key: key, Value: v, Soft: soft, Slide: slide})
+	}
+}
+
+// Record that a key was removed, must be called while the key's shard is locked
+// key -> The key that was removed
+func (w *wal[K, V]) logDelete(key K) {
+	if w != nil {
+		w.append(walRecord[K, V]{Op: walDelete, Key: key})
+	}
+}
+
+// Record that the expiration of a key changed, must be called while the key's shard is locked
+// key -> The key
+// soft -> The new expiration timestamp in Unix UTC nanoseconds
+// slide -> The new sliding expiration
+func (w *wal[K, V]) logExpiry(key K, soft int64, slide time.Duration) {
+	if w != nil {
+		w.append(walRecord[K, V]{Op: walExpiry, Key: key, Soft: soft, Slide: slide})
+	}
+}
+
+// Record that every key was removed, must be called while every shard is locked
+func (w *wal[K, V]) logClear() {
+	if w != nil {
+		w.append(walRecord[K, V]{Op: walClear})
+	}
+}
+
+// Frame a record and append it to the current segment
+// Writes can't fail, so what goes wrong is logged and passed to the error handler
+// rec -> The record
+func (w *wal[K, V]) append(rec walRecord[K, V]) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.closed {
+		return
+	}
+	if err := w.write(rec); err != nil {
+		w.logger.Error("godistcache: writing to the write-ahead log failed", "err", err)
+		if w.onError != nil {
+			w.onError(err)
+		}
+	}
+}
+
+// Frame a record and append it to the current segment, must hold the lock
+// rec -> The record
+func (w *wal[K, V]) write(rec walRecord[K, V]) error {
+	// A segment that couldn't be created is tried again
+	if w.f == nil {
+		if err := w.rotateLocked(); err != nil {
+			return err
+		}
+	}
+	w.buf.Reset()
+	if err := w.enc.Encode(&rec); err != nil {
+		// The encoder can't be trusted after a failure, the next record starts a new segment
+		if rerr := w.rotateLocked(); rerr != nil {
+			return errors.Join(err, rerr)
+		}
+		return err
+	}
+	payload := w.buf.Bytes()
+	frame := binary.BigEndian.AppendUint32(make([]byte, 0, len(payload)+8), uint32(len(payload)))
+	frame = binary.BigEndian.AppendUint32(frame, crc32.Checksum(payload, castagnoli))
+	frame = append(frame, payload...)
+	if _, err := w.f.Write(frame); err != nil {
+		// Replay stops at a partly written frame, so nothing more can go in this segment
+		return errors.Join(err, w.rotateLocked())
+	}
+	w.size += int64(len(frame))
+	w.dirty = true
+	if w.policy == WALSyncAlways {
+		if err := w.syncLocked(); err != nil {
+			return err
+		}
+	}
+	if w.size >= w.segmentSize {
+		return w.rotateLocked()
+	}
+	return nil
+}
+
+// Flush the current segment to disk if anything was written since the last time, must hold the lock
+func (w *wal[K, V]) syncLocked() error {
+	if !w.dirty || w.f == nil {
+		return nil
+	}
+	w.dirty = false
+	return w.f.Sync()
+}
+
+// Flush the log to disk once a second until it's closed
+// clock -> Times the flushes
+func (w *wal[K, V]) syncLoop(clock Clock) {
+	defer close(w.done)
+	for {
+		select {
+		case <-w.stop:
+			return
+		case <-clock.After(time.Second):
+		}
+		w.mu.Lock()
+		err := w.syncLocked()
+		w.mu.Unlock()
+		if err != nil {
+			w.logger.Error("godistcache: flushing the write-ahead log failed", "err", err)
+			if w.onError != nil {
+				w.onError(err)
+			}
+		}
+	}
+}
+
+// Start a new segment, returns its number
+// Everything a snapshot taken after this holds is in the new segment or older ones, so the older ones can go once it's saved
+func (w *wal[K, V]) rotate() (uint64, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.closed {
+		return w.seq, nil
+	}
+	err := w.rotateLocked()
+	return w.seq, err
+}
+
+// Close the current segment and start the next one, must hold the lock
+func (w *wal[K, V]) rotateLocked() error {
+	var err error
+	if w.f != nil {
+		err = w.syncLocked()
+		if cerr := w.f.Close(); err == nil {
+			err = cerr
+		}
+	}
+	w.f = nil
+	w.seq++
+	if oerr := w.openSegment(); oerr != nil {
+		return errors.Join(err, oerr)
+	}
+	return err
+}
+
+// Delete the segments older than seq, a snapshot holds everything they recorded
+// The store replayed the previous run when the log was attached, so its segments go too and are no longer replayed by loads
+// seq -> The number returned by rotate before the snapshot was taken
+func (w *wal[K, V]) compact(seq uint64) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	segments, err := w.segments()
+	if err != nil {
+		return err
+	}
+	w.pending = slices.DeleteFunc(w.pending, func(s uint64) bool { return s < seq })
+	var errs []error
+	for _, s := range segments {
+		if s < seq {
+			if err := os.Remove(w.path(s)); err != nil && !errors.Is(err, os.ErrNotExist) {
+				errs = append(errs, err)
+			}
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// Flush and close the log, writes after this aren't logged
+func (w *wal[K, V]) close() error {
+	if w == nil {
+		return nil
+	}
+	if w.stop != nil {
+		close(w.stop)
+		<-w.done
+		w.stop = nil
+	}
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.closed || w.f == nil {
+		w.closed = true
+		return nil
+	}
+	w.closed = true
+	err := w.syncLocked()
+	if cerr := w.f.Close(); err == nil {
+		err = cerr
+	}
+	w.f = nil
+	return err
+}
+
+// Apply the segments left by the previous run to items, only the first call does anything
+// A record that was torn by a crash ends its segment, everything before it is applied
+// items -> The items of the snapshot the log continues from
+func (w *wal[K, V]) replay(items map[K]importedItem[V]) error {
+	if w == nil {
+		return nil
+	}
+	w.mu.Lock()
+	pending := w.pending
+	w.pending = nil
+	w.mu.Unlock()
+	return w.replaySegments(pending, items)
+}
+
+// Apply segments to items in order
+// seqs -> The numbers of the segments
+// items -> The items to apply them to
+func (w *wal[K, V]) replaySegments(seqs []uint64, items map[K]importedItem[V]) error {
+	for _, seq := range seqs {
+		if err := w.replaySegment(seq, items); err != nil {
+			return fmt.Errorf("godistcache: replaying %v: %w", w.path(seq), err)
+		}
+	}
+	return nil
+}
+
+// Apply one segment to items
+// seq -> The number of the segment
+// items -> The items to apply it to
+func (w *wal[K, V]) replaySegment(seq uint64, items map[K]importedItem[V]) error {
+	f, err := os.Open(w.path(seq))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	frames := &frameReader{r: bufio.NewReader(f)}
+	dec := gob.NewDecoder(frames)
+	for {
+		var rec walRecord[K, V]
+		if err := dec.Decode(&rec); errors.Is(err, io.EOF) {
+			break
+		} else if err != nil {
+			return err
+		}
+		switch rec.Op {
+		case walPut:
+			items[rec.Key] = importedItem[V]{v: rec.Value, soft: rec.Soft, slide: rec.Slide}
+		case walDelete:
+			delete(items, rec.Key)
+		case walClear:
+			clear(items)
+		case walExpiry:
+			if i, ok := items[rec.Key]; ok {
+				i.soft, i.slide = rec.Soft, rec.Slide
+				items[rec.Key] = i
+			}
+		}
+	}
+	if frames.torn {
+		w.logger.Warn("godistcache: dropped a torn record at the end of the write-ahead log", "segment", w.path(seq))
+	}
+	return nil
+}
+
+// Reads the records of a segment one frame at a time, ending at the first frame that's incomplete or fails its checksum
+type frameReader struct {
+	r    io.Reader
+	buf  []byte // The frame being read
+	torn bool   // The segment ended with a broken frame
+}
+
+func (f *frameReader) Read(p []byte) (int, error) {
+	for len(f.buf) == 0 {
+		var head [8]byte
+		if _, err := io.ReadFull(f.r, head[:]); err != nil {
+			f.torn = !errors.Is(err, io.EOF)
+			return 0, io.EOF
+		}
+		payload := make([]byte, binary.BigEndian.Uint32(head[:4]))
+		if _, err := io.ReadFull(f.r, payload); err != nil || crc32.Checksum(payload, castagnoli) != binary.BigEndian.Uint32(head[4:]) {
+			f.torn = true
+			return 0, io.EOF
+		}
+		f.buf = payload
+	}
+	n := copy(p, f.buf)
+	f.buf = f.buf[n:]
+	return n, nil
+}
+
+// Take a snapshot with save, then delete the segments of the log it made redundant
+// log -> The log of the cache, nil without one
+// save -> Saves the snapshot
+func checkpoint(log checkpointer, save func() error) error {
+	if log == nil {
+		return save()
+	}
+	seq, err := log.rotate()
+	if err != nil {
+		return err
+	}
+	if err := save(); err != nil {
+		return err
+	}
+	return log.compact(seq)
+}
+
+// Changes the items decoded from a snapshot before they replace the store
+// h -> The header of the snapshot
+// items -> The decoded items, changed in place
+type loadStep[K comparable, V any] func(h SnapshotHeader, items map[K]importedItem[V]) error
+
+// Replays the log on top of the decoded items, nil without a log
+// s -> The store being loaded
+func replayStep[K comparable, V any](s *store[K, V]) loadStep[K, V] {
+	if s.wal == nil {
+		return nil
+	}
+	return func(h SnapshotHeader, items map[K]importedItem[V]) error {
+		return s.wal.replay(items)
+	}
+}
+
+// Run the steps over a decoded snapshot, then replace the store with the result
+// s -> The store to replace
+// h -> The header of the snapshot
+// m -> The decoded snapshot
+// unwrap -> Converts an item of the snapshot into its value, expiration and sliding expiration
+// steps -> Run in order, nil steps are skipped
+func restoreWith[K comparable, V any, I any](s *store[K, V], h SnapshotHeader, m map[K]I, unwrap func(I) (V, int64, time.Duration), steps ...loadStep[K, V]) error {
+	if !slices.ContainsFunc(steps, func(step loadStep[K, V]) bool { return step != nil }) {
+		restore(s, m, unwrap)
+		return nil
+	}
+	items, err := applySteps(h, m, unwrap, steps)
+	if err != nil {
+		return err
+	}
+	restore(s, items, unwrapImported[V])
+	return nil
+}
+
+// Converts a decoded snapshot into items and runs the steps over them
+// h -> The header of the snapshot
+// m -> The decoded snapshot
+// unwrap -> Converts an item of the snapshot into its value, expiration and sliding expiration
+// steps -> Run in order, nil steps are skipped
+func applySteps[K comparable, V any, I any](h SnapshotHeader, m map[K]I, unwrap func(I) (V, int64, time.Duration), steps []loadStep[K, V]) (map[K]importedItem[V], error) {
+	items := make(map[K]importedItem[V], len(m))
+	for k, i := range m {
+		v, soft, slide := unwrap(i)
+		items[k] = importedItem[V]{v: v, soft: soft, slide: slide}
+	}
+	for _, step := range steps {
+		if step == nil {
+			continue
+		}
+		if err := step(h, items); err != nil {
+			return nil, err
+		}
+	}
+	return items, nil
+}
+
+// Returns the value, expiration and sliding expiration of an imported item
+func unwrapImported[V any](i importedItem[V]) (V, int64, time.Duration) {
+	return i.v, i.soft, i.slide
+}
+
+// Mark the log of the previous run replayed, the store already holds it since the log was attached
+// Replaying it again would undo the writes made since
+// s -> The store
+func replayWAL[K comparable, V any](s *store[K, V]) error {
+	if s.wal == nil {
+		return nil
+	}
+	s.wal.mu.Lock()
+	s.wal.pending = nil
+	s.wal.mu.Unlock()
+	return nil
+}
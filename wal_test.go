@@ -0,0 +1,245 @@
+package godistcache
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/mbarreca/godistcache/storage"
+)
+
+// Returns the segments of the log in dir
+func walSegments(t *testing.T, dir string) []string {
+	t.Helper()
+	segments, err := filepath.Glob(filepath.Join(dir, "*"+walExtension))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return segments
+}
+
+func TestWALReplay(t *testing.T) {
+	dir := t.TempDir()
+	snap := filepath.Join(t.TempDir(), "snap")
+	opts := []Option{WithWAL(dir), WithWALSync(WALSyncAlways)}
+	c := newTestTyped[string, int](t, opts...)
+	c.Put("a", 1)
+	c.Put("b", 2)
+	if err := c.SaveToBinaryFile(snap); err != nil {
+		t.Fatal(err)
+	}
+	// The snapshot holds everything logged so far
+	if n := len(walSegments(t, dir)); n != 1 {
+		t.Fatalf("Saving a snapshot left %v segments", n)
+	}
+	c.Put("a", 10)
+	c.Delete("b")
+	c.PutTTL("c", 3, time.Hour)
+	c.Persist("c")
+	c.PutTTL("d", 4, time.Hour)
+	c.Touch("d", 2*time.Hour)
+	// Crash without closing, the second cache loads the snapshot and replays the log
+	c2 := newTestTyped[string, int](t, opts...)
+	if err := c2.LoadFromBinary(snap); err != nil {
+		t.Fatal(err)
+	}
+	if v, _ := c2.Get("a"); v != 10 {
+		t.Fatalf("Put wasn't replayed: %v", v)
+	}
+	if c2.Exists("b") {
+		t.Fatalf("Delete wasn't replayed")
+	}
+	if ttl, _ := c2.TTL("c"); ttl != NoExpiration {
+		t.Fatalf("Persist wasn't replayed: %v", ttl)
+	}
+	if ttl, _ := c2.TTL("d"); ttl <= time.Hour {
+		t.Fatalf("Touch wasn't replayed: %v", ttl)
+	}
+	// The log is only replayed once
+	c2.Put("a", 20)
+	if err := c2.ReplayWAL(); err != nil {
+		t.Fatal(err)
+	}
+	if v, _ := c2.Get("a"); v != 20 {
+		t.Fatalf("Log was replayed twice: %v", v)
+	}
+	c.s.wal.close()
+	if err := c2.Close(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestWALClearAndTornTail(t *testing.T) {
+	dir := t.TempDir()
+	c, err := New(context.Background(), WithWAL(dir), WithWALSync(WALSyncNever))
+	if err != nil {
+		t.Fatal(err)
+	}
+	c.Put("a", "a")
+	c.Clear()
+	c.Put("b", "b")
+	if err := c.Close(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	// A record cut short by a crash
+	segments := walSegments(t, dir)
+	f, err := os.OpenFile(segments[len(segments)-1], os.O_APPEND|os.O_WRONLY, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.Write([]byte{0, 0, 1, 0, 1, 2})
+	f.Close()
+	// Without a snapshot the cache starts from the log
+	c2, err := New(context.Background(), WithWAL(dir))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c2.Exists("a") || !c2.Exists("b") || c2.Count() != 1 {
+		t.Fatalf("Unexpected items after replaying: %v", c2.Count())
+	}
+	c2.Close(context.Background())
+}
+
+func TestWALPersistence(t *testing.T) {
+	dir := t.TempDir()
+	opts := []Option{WithWAL(dir), WithBackend(storage.NewMemory()), WithWALSegmentSize(64)}
+	c := newTestTyped[int, string](t, opts...)
+	for i := 0; i < 100; i++ {
+		c.Put(i, "value")
+	}
+	// Small segments roll over while writing
+	if n := len(walSegments(t, dir)); n < 10 {
+		t.Fatalf("Segments didn't roll over: %v", n)
+	}
+	// The final upload covers the whole log
+	if err := c.Close(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if n := len(walSegments(t, dir)); n != 1 {
+		t.Fatalf("Persisting left %v segments", n)
+	}
+	c2, err := NewTypedFromBackend[int, string]("cache", context.Background(), opts...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c2.Count() != 100 {
+		t.Fatalf("Expected 100 items, got %v", c2.Count())
+	}
+	c2.Close(context.Background())
+}
+
+func TestWALSaveBeforeReplay(t *testing.T) {
+	dir := t.TempDir()
+	snap := filepath.Join(t.TempDir(), "snap")
+	opts := []Option{WithWAL(dir), WithWALSync(WALSyncAlways)}
+	c := newTestTyped[string, int](t, opts...)
+	c.Put("a", 1)
+	c.s.wal.close()
+	// After a crash with no snapshot to load, the cache starts from the log
+	c2 := newTestTyped[string, int](t, opts...)
+	if v, ok := c2.Get("a"); !ok || v != 1 {
+		t.Fatalf("The log of the previous run wasn't replayed: %v, %v", v, ok)
+	}
+	c2.Put("a", 2)
+	if err := c2.SaveToBinaryFile(snap); err != nil {
+		t.Fatal(err)
+	}
+	c2.s.wal.close()
+	// The snapshot holds the log of the first run, which must not be replayed over it
+	c3 := newTestTyped[string, int](t, opts...)
+	if err := c3.LoadFromBinary(snap); err != nil {
+		t.Fatal(err)
+	}
+	if v, _ := c3.Get("a"); v != 2 {
+		t.Fatalf("An older run was replayed over a newer snapshot: %v", v)
+	}
+	c3.Close(context.Background())
+}
+
+func TestWALReplaceLoads(t *testing.T) {
+	dir := t.TempDir()
+	snap := filepath.Join(t.TempDir(), "snap")
+	opts := []Option{WithWAL(dir), WithWALSync(WALSyncAlways)}
+	c := newTestTyped[string, int](t, opts...)
+	c.Put("a", 1)
+	if err := c.SaveToBinaryFile(snap); err != nil {
+		t.Fatal(err)
+	}
+	var export bytes.Buffer
+	if err := c.ExportJSON(&export); err != nil {
+		t.Fatal(err)
+	}
+	// Writes before a load are replaced by it, also after a crash
+	c.Put("scratch", 2)
+	if err := c.LoadFromBinary(snap); err != nil {
+		t.Fatal(err)
+	}
+	c.Put("b", 3)
+	if err := c.ImportJSON(&export); err != nil {
+		t.Fatal(err)
+	}
+	c.s.wal.close()
+	c2 := newTestTyped[string, int](t, opts...)
+	if err := c2.LoadFromBinary(snap); err != nil {
+		t.Fatal(err)
+	}
+	if c2.Exists("scratch") || c2.Exists("b") || !c2.Exists("a") {
+		t.Fatalf("Writes replaced by a load came back after a crash: %v items", c2.Count())
+	}
+	c2.Close(context.Background())
+}
+
+func TestSlidingReadsAreNotLogged(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+	backend := storage.NewMemory()
+	opts := []Option{WithBackend(backend), WithObjectKey("test"), WithDeltaSnapshots(10), WithWAL(dir), WithWALSync(WALSyncAlways)}
+	c := newTestTyped[string, int](t, opts...)
+	c.PutSliding("session", 1, 60)
+	if err := c.p.persist(ctx, c.WriteSnapshot); err != nil {
+		t.Fatal(err)
+	}
+	logged := func() int64 {
+		var size int64
+		for _, segment := range walSegments(t, dir) {
+			info, err := os.Stat(segment)
+			if err != nil {
+				t.Fatal(err)
+			}
+			size += info.Size()
+		}
+		return size
+	}
+	before := logged()
+	for i := 0; i < 10; i++ {
+		if _, ok := c.Get("session"); !ok {
+			t.Fatalf("Sliding item is missing")
+		}
+	}
+	if err := c.p.persist(ctx, c.WriteSnapshot); err != nil {
+		t.Fatal(err)
+	}
+	if after := logged(); after != before {
+		t.Fatalf("Reads grew the log from %v to %v bytes", before, after)
+	}
+	if n := len(deltaObjects(t, backend)); n != 0 {
+		t.Fatalf("Reads uploaded %v deltas", n)
+	}
+	// Touch is a write
+	c.Touch("session", time.Hour)
+	if logged() == before {
+		t.Fatalf("Touch wasn't logged")
+	}
+	if err := c.p.persist(ctx, c.WriteSnapshot); err != nil {
+		t.Fatal(err)
+	}
+	if n := len(deltaObjects(t, backend)); n != 1 {
+		t.Fatalf("Touch uploaded %v deltas", n)
+	}
+	if err := c.Close(ctx); err != nil {
+		t.Fatal(err)
+	}
+}